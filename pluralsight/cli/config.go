@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file holds defaults for any flag, keyed by the flag name,
+// plus default inputs and named queries:
+//
+//	format: json
+//	min-level: WARNING
+//	redact: true
+//	redact-pattern: ['session=(?P<secret>\w+)']
+//	inputs: [/var/log/myapp/app.log]
+//	queries:
+//	  slow-db: 'message has timeout AND level >= ERROR'
+//
+// A saved query is used with -query @slow-db. Every flag can also be set
+// through an environment variable named LOGFILTER_ plus the flag name in
+// upper case with dashes turned into underscores, e.g. LOGFILTER_MIN_LEVEL.
+// Precedence, lowest first: built-in defaults, the config file, the
+// environment, the command line.
+
+// envPrefix starts the name of every environment variable read.
+const envPrefix = "LOGFILTER_"
+
+// config is the parsed config file.
+type config struct {
+	path     string
+	found    bool                // false when there is no config file
+	settings map[string][]string // flag name → values, several for repeatable flags
+	inputs   []string
+	queries  map[string]string
+}
+
+// configPath returns the config file location: $LOGFILTER_CONFIG, or
+// logfilter/config.yaml under $XDG_CONFIG_HOME (by default ~/.config).
+func configPath() string {
+	if p := os.Getenv(envPrefix + "CONFIG"); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "logfilter", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig() (*config, error) {
+	c := &config{path: configPath(), settings: map[string][]string{}}
+	if c.path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.found = true
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path, err)
+	}
+	for key, value := range raw {
+		switch name := strings.ReplaceAll(key, "_", "-"); name {
+		case "inputs":
+			if c.inputs, err = stringList(value); err != nil {
+				return nil, fmt.Errorf("%s: inputs: %w", c.path, err)
+			}
+		case "queries":
+			m, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: queries: want a map of names to query expressions", c.path)
+			}
+			c.queries = map[string]string{}
+			for k, v := range m {
+				c.queries[k] = fmt.Sprint(v)
+			}
+		default:
+			if c.settings[name], err = stringList(value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", c.path, key, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// stringList turns a scalar or a list of scalars into strings.
+func stringList(value any) ([]string, error) {
+	switch v := value.(type) {
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(map[string]any); ok {
+				return nil, fmt.Errorf("want a value or a list of values")
+			}
+			out = append(out, fmt.Sprint(item))
+		}
+		return out, nil
+	case map[string]any:
+		return nil, fmt.Errorf("want a value or a list of values")
+	case nil:
+		return nil, nil
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
+
+// envName returns the environment variable that sets the named flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseArgs parses args into fs, then fills every flag the command line
+// left unset from the environment or, failing that, the config file. It
+// also stores the configured inputs and saved queries in o, and returns
+// where each set flag came from, for `config show`.
+func parseArgs(fs *flag.FlagSet, args []string, o *options) map[string]string {
+	fs.Parse(args)
+	sources := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = "flag" })
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	for name := range cfg.settings {
+		if !knownSetting(name) {
+			fatalf("%s: unknown setting %q", cfg.path, name)
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] != "" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				fatalf("$%s: %v", envName(f.Name), err)
+			}
+			sources[f.Name] = "env " + envName(f.Name)
+			return
+		}
+		for _, v := range cfg.settings[f.Name] {
+			if err := fs.Set(f.Name, v); err != nil {
+				fatalf("%s: %s: %v", cfg.path, f.Name, err)
+			}
+			sources[f.Name] = cfg.path
+		}
+	})
+
+	// Inputs may be listed like $PATH in the environment.
+	if v := os.Getenv(envPrefix + "INPUTS"); v != "" {
+		o.defaultInputs = filepath.SplitList(v)
+		sources["inputs"] = "env " + envPrefix + "INPUTS"
+	} else if len(cfg.inputs) > 0 {
+		o.defaultInputs = cfg.inputs
+		sources["inputs"] = cfg.path
+	}
+	o.queries = cfg.queries
+	o.configPath, o.configFound = cfg.path, cfg.found
+	return sources
+}
+
+// sourceRank orders the sources returned by parseArgs by precedence:
+// the command line, then the environment, then the config file, with 0 for
+// a built-in default.
+func sourceRank(source string) int {
+	switch {
+	case source == "":
+		return 0
+	case source == "flag":
+		return 3
+	case strings.HasPrefix(source, "env "):
+		return 2
+	}
+	return 1
+}
+
+// knownSetting reports whether name is a flag of any command.
+func knownSetting(name string) bool {
+	for _, c := range commands {
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(newOptions(), fs)
+		if fs.Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// runConfig implements `logfilter config show [flags]`, which prints the
+// effective value of every filter setting and where it came from. Flags
+// given after show are taken into account like in filter.
+func runConfig(c command, args []string) int {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], c.name, c.args)
+		return exitError
+	}
+	filter := commands[0]
+	o := newOptions()
+	fs := newFlagSet(filter)
+	filter.setup(o, fs)
+	sources := parseArgs(fs, args[1:], o)
+
+	found := "not found"
+	if o.configFound {
+		found = "found"
+	}
+	fmt.Printf("config file: %s (%s)\n\n", o.configPath, found)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	source := func(name string) string {
+		if s := sources[name]; s != "" {
+			return s
+		}
+		return "default"
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		// Repeatable flags keep their values in o rather than in the flag.
+		switch f.Name {
+		case "extract":
+			var patterns []string
+			for _, x := range o.Extractors {
+				patterns = append(patterns, fmt.Sprint(x))
+			}
+			value = strings.Join(patterns, " ")
+		case "redact-pattern":
+			value = strings.Join(o.RedactPatterns, " ")
+		case "redact-key":
+			// The key keeps redaction hashes from being reversed; it is a secret.
+			if value != "" {
+				value = "(set)"
+			}
+		case "offset":
+			var offsets []string
+			for name, d := range o.Offsets {
+				offsets = append(offsets, name+"="+d.String())
+			}
+			slices.Sort(offsets)
+			value = strings.Join(offsets, " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, value, source(f.Name))
+	})
+	fmt.Fprintf(tw, "inputs\t%s\t%s\n", strings.Join(o.defaultInputs, " "), source("inputs"))
+	names := make([]string, 0, len(o.queries))
+	for n := range o.queries {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	for _, n := range names {
+		fmt.Fprintf(tw, "queries.%s\t%s\t%s\n", n, o.queries[n], o.configPath)
+	}
+	if err := tw.Flush(); err != nil {
+		fatal(err)
+	}
+	return exitMatch
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"cli/logfilter"
+)
+
+// runIndex implements `logfilter index FILE...`: it writes a sidecar index
+// next to every file, which later filter runs use automatically while the
+// file is unchanged.
+func runIndex(c command, args []string) int {
+	o := newOptions()
+	fs := newFlagSet(c)
+	c.setup(o, fs)
+	parseArgs(fs, args, o)
+
+	if c.requiresFiles && fs.NArg() == 0 {
+		fs.Usage()
+		return exitError
+	}
+	bucket, err := logfilter.ParseBucket(o.Bucket)
+	if err != nil {
+		fatalf("-bucket: %v", err)
+	}
+	parser, format, err := o.Parsing()
+	if err != nil {
+		fatal(err)
+	}
+	inputs, err := logfilter.ExpandInputs(fs.Args(), o.recursive)
+	if err != nil {
+		fatal(err)
+	}
+	for _, name := range inputs {
+		if name == logfilter.StdinName {
+			fatal("standard input cannot be indexed")
+		}
+		idx, err := logfilter.BuildIndex(name, format, parser, bucket)
+		if err != nil {
+			fatal(err)
+		}
+		if err := logfilter.WriteIndex(name, idx); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s: %d lines, %d levels, %d words (%s)\n", logfilter.IndexPath(name), idx.Lines(), len(idx.Levels), len(idx.Words), idx.Format)
+	}
+	return exitMatch
+}
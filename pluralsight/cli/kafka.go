@@ -0,0 +1,132 @@
+//go:build cgo
+
+// The Kafka output uses confluent-kafka-go, which wraps librdkafka through
+// cgo (the module ships librdkafka prebuilt for common platforms). Builds
+// with CGO_ENABLED=0 leave it out and reject -kafka-topic.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"cli/logfilter"
+)
+
+func init() {
+	newKafkaProducer = newConfluentProducer
+}
+
+// confluentProducer adapts a confluent-kafka-go producer to
+// logfilter.Producer.
+type confluentProducer struct {
+	p *kafka.Producer
+
+	// Delivery reports arrive on the events goroutine, possibly after
+	// p.Flush has returned, so Flush waits until every produced message
+	// has its report.
+	mu          sync.Mutex
+	outstanding int           // produced messages without a delivery report
+	reported    chan struct{} // closed and replaced on every delivery report
+	failed      []logfilter.DeliveryError
+}
+
+// newConfluentProducer connects to brokers the way Examples/Kafka/producer.go
+// does. The client sends up to batch messages per request, waiting briefly
+// for a batch to fill.
+func newConfluentProducer(brokers string, batch int) (logfilter.Producer, error) {
+	return newConfluentProducerConfig(&kafka.ConfigMap{
+		"bootstrap.servers":  brokers,
+		"batch.num.messages": batch,
+		"linger.ms":          50,
+	})
+}
+
+// newConfluentProducerConfig creates the producer from a complete client
+// configuration.
+func newConfluentProducerConfig(config *kafka.ConfigMap) (*confluentProducer, error) {
+	p, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+	c := &confluentProducer{p: p, reported: make(chan struct{})}
+
+	// Delivery report handler for produced messages. Every report settles
+	// one outstanding message; failures are collected for the next Flush.
+	go func() {
+		for e := range p.Events() {
+			ev, ok := e.(*kafka.Message)
+			if !ok {
+				continue
+			}
+			c.mu.Lock()
+			if ev.TopicPartition.Error != nil {
+				topic := ""
+				if ev.TopicPartition.Topic != nil {
+					topic = *ev.TopicPartition.Topic
+				}
+				c.failed = append(c.failed, logfilter.DeliveryError{
+					Message: logfilter.KafkaMessage{Topic: topic, Key: ev.Key, Value: ev.Value},
+					Err:     ev.TopicPartition.Error,
+				})
+			}
+			c.outstanding--
+			close(c.reported)
+			c.reported = make(chan struct{})
+			c.mu.Unlock()
+		}
+	}()
+	return c, nil
+}
+
+func (c *confluentProducer) Produce(msg logfilter.KafkaMessage) error {
+	topic := msg.Topic
+	// Counted first: the report may arrive before Produce returns.
+	c.mu.Lock()
+	c.outstanding++
+	c.mu.Unlock()
+	err := c.p.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+	}, nil)
+	if err != nil {
+		// Rejected messages get no delivery report.
+		c.mu.Lock()
+		c.outstanding--
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Flush waits for librdkafka to send the queued messages, then for the
+// events goroutine to handle their delivery reports. Messages without a
+// report when timeout has passed are counted as still queued.
+func (c *confluentProducer) Flush(timeout time.Duration) ([]logfilter.DeliveryError, int) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	c.p.Flush(int(timeout / time.Millisecond))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for waiting := true; waiting && c.outstanding > 0; {
+		reported := c.reported
+		c.mu.Unlock()
+		select {
+		case <-reported:
+		case <-timer.C:
+			waiting = false
+		}
+		c.mu.Lock()
+	}
+	failed := c.failed
+	c.failed = nil
+	return failed, c.outstanding
+}
+
+func (c *confluentProducer) Close() error {
+	c.p.Close()
+	return nil
+}
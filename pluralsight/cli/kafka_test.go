@@ -0,0 +1,75 @@
+//go:build cgo
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"cli/logfilter"
+)
+
+func TestConfluentProducerFlushWaitsForReports(t *testing.T) {
+	// Nothing listens on the broker address, so every message fails once
+	// its short timeout expires. Flush must return all of those failures,
+	// not just the ones handled before librdkafka's own flush returned.
+	c, err := newConfluentProducerConfig(&kafka.ConfigMap{
+		"bootstrap.servers":  "127.0.0.1:1",
+		"message.timeout.ms": 200,
+		"linger.ms":          0,
+		"log_level":          0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const n = 20
+	for i := range n {
+		if err := c.Produce(logfilter.KafkaMessage{Topic: "logs", Key: []byte("k"), Value: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed, queued := c.Flush(10 * time.Second)
+	if len(failed) != n || queued != 0 {
+		t.Fatalf("Flush = %d failure(s), %d queued; want %d failures, 0 queued", len(failed), queued, n)
+	}
+	for _, f := range failed {
+		if f.Message.Topic != "logs" || f.Err == nil {
+			t.Errorf("failure = %+v", f)
+		}
+	}
+
+	// The reports were consumed: a second Flush has nothing to return.
+	if failed, queued := c.Flush(time.Second); len(failed) != 0 || queued != 0 {
+		t.Errorf("second Flush = %d failure(s), %d queued", len(failed), queued)
+	}
+}
+
+func TestConfluentProducerFlushTimeout(t *testing.T) {
+	// With the default message timeout no report arrives in time, so the
+	// messages are still queued when Flush gives up.
+	c, err := newConfluentProducerConfig(&kafka.ConfigMap{
+		"bootstrap.servers": "127.0.0.1:1",
+		"log_level":         0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for range 3 {
+		if err := c.Produce(logfilter.KafkaMessage{Topic: "logs", Value: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	failed, queued := c.Flush(300 * time.Millisecond)
+	if len(failed) != 0 || queued != 3 {
+		t.Errorf("Flush = %d failure(s), %d queued; want 0 failures, 3 queued", len(failed), queued)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Flush took %v with a 300ms timeout", d)
+	}
+}
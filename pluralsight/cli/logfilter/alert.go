@@ -0,0 +1,392 @@
+package logfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// An alert rules file lists rules that are evaluated against every parsed
+// record. YAML and JSON are both accepted (JSON is valid YAML):
+//
+//	rules:
+//	  - name: critical-burst
+//	    query: level = CRITICAL
+//	    threshold: 5        # fire on more than 5 matches...
+//	    window: 1m          # ...within one minute (without a window, in total)
+//	    cooldown: 10m       # then stay quiet for ten minutes
+//	    actions:
+//	      - type: stderr
+//	      - type: webhook
+//	        url: https://hooks.example.com/logs
+//	  - name: emergency
+//	    query: level >= EMERGENCY   # threshold 0: any match fires
+//	    actions:
+//	      - type: exec
+//	        command: ["notify-send", "log emergency"]
+
+// RuleConfig is one rule as written in a rules file.
+type RuleConfig struct {
+	Name      string         `yaml:"name"`
+	Query     string         `yaml:"query"`
+	Threshold int            `yaml:"threshold"`
+	Window    time.Duration  `yaml:"window"`
+	Cooldown  time.Duration  `yaml:"cooldown"`
+	Actions   []ActionConfig `yaml:"actions"`
+}
+
+// ActionConfig describes what a rule does when it fires. Type is stderr,
+// exec (Command is run with the alert as JSON on its standard input) or
+// webhook (the alert is POSTed as JSON to URL).
+type ActionConfig struct {
+	Type    string   `yaml:"type"`
+	Command []string `yaml:"command"`
+	URL     string   `yaml:"url"`
+}
+
+// Alert is the payload passed to actions when a rule fires.
+type Alert struct {
+	Rule   string     `json:"rule"`
+	Count  int        `json:"count"`
+	Window string     `json:"window,omitempty"`
+	Time   time.Time  `json:"time"`
+	Record jsonRecord `json:"record"` // the record that triggered the alert
+}
+
+// payload encodes the alert as sent to exec and webhook actions. Queries
+// such as "level >= ERROR" are kept readable rather than HTML-escaped.
+func (al Alert) payload() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(al); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Action performs the side effect of a fired rule.
+type Action interface {
+	Fire(ctx context.Context, a Alert) error
+}
+
+// Rule is a compiled alert rule: it fires when more than Threshold records
+// accepted by Query occur within Window, and then not again for Cooldown.
+// Without a Window, matches are counted since the start or the last alert.
+type Rule struct {
+	Name      string
+	Query     Query
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+	Actions   []Action
+
+	hits      []time.Time // times of the matches inside the window
+	total     int         // matches since the last alert, without a window
+	lastFired time.Time
+}
+
+// observe records a match at now and reports whether the rule fires.
+func (r *Rule) observe(now time.Time) (count int, fire bool) {
+	if r.Window <= 0 {
+		r.total++
+		count = r.total
+	} else {
+		// Drop hits that have slid out of the window.
+		r.hits = append(r.hits, now)
+		i := 0
+		for i < len(r.hits) && now.Sub(r.hits[i]) >= r.Window {
+			i++
+		}
+		r.hits = r.hits[i:]
+		count = len(r.hits)
+	}
+
+	if count <= r.Threshold {
+		return count, false
+	}
+	if !r.lastFired.IsZero() && now.Sub(r.lastFired) < r.Cooldown {
+		return count, false
+	}
+	r.lastFired = now
+	// The next alert needs a fresh set of matches.
+	r.hits, r.total = r.hits[:0], 0
+	return count, true
+}
+
+// LoadRules reads a YAML or JSON rules file and compiles its rules. Queries
+// are parsed with p, like -query.
+func LoadRules(path string, p Parser) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Rules []RuleConfig `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules defined", path)
+	}
+	rules := make([]*Rule, 0, len(file.Rules))
+	for i, rc := range file.Rules {
+		rule, err := compileRule(rc, p)
+		if err != nil {
+			name := rc.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("%s: rule %s: %w", path, name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compileRule(rc RuleConfig, p Parser) (*Rule, error) {
+	if rc.Query == "" {
+		return nil, fmt.Errorf("missing query")
+	}
+	if rc.Threshold < 0 || rc.Window < 0 || rc.Cooldown < 0 {
+		return nil, fmt.Errorf("threshold, window and cooldown cannot be negative")
+	}
+	q, err := ParseQuery(rc.Query, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{Name: rc.Name, Query: q, Threshold: rc.Threshold, Window: rc.Window, Cooldown: rc.Cooldown}
+	if rule.Name == "" {
+		rule.Name = rc.Query
+	}
+	if len(rc.Actions) == 0 {
+		rc.Actions = []ActionConfig{{Type: "stderr"}}
+	}
+	for _, ac := range rc.Actions {
+		action, err := newAction(ac)
+		if err != nil {
+			return nil, err
+		}
+		rule.Actions = append(rule.Actions, action)
+	}
+	return rule, nil
+}
+
+func newAction(ac ActionConfig) (Action, error) {
+	switch ac.Type {
+	case "stderr", "":
+		return &WriterAction{W: os.Stderr}, nil
+	case "exec":
+		if len(ac.Command) == 0 {
+			return nil, fmt.Errorf("exec action needs a command")
+		}
+		return &ExecAction{Command: ac.Command}, nil
+	case "webhook":
+		if ac.URL == "" {
+			return nil, fmt.Errorf("webhook action needs a url")
+		}
+		return &WebhookAction{URL: ac.URL}, nil
+	}
+	return nil, fmt.Errorf("unknown action type %q (want stderr, exec or webhook)", ac.Type)
+}
+
+// WriterAction prints a one-line summary of the alert to W.
+type WriterAction struct {
+	W io.Writer
+}
+
+// Fire implements Action.
+func (a *WriterAction) Fire(_ context.Context, al Alert) error {
+	window := ""
+	if al.Window != "" {
+		window = " in " + al.Window
+	}
+	_, err := fmt.Fprintf(a.W, "ALERT %s: %d match(es)%s, last: %s %s\n", al.Rule, al.Count, window, al.Record.Level, al.Record.Message)
+	return err
+}
+
+// ExecAction runs Command with the alert as JSON on its standard input.
+// The rule name is also available as $LOGFILTER_ALERT_RULE.
+type ExecAction struct {
+	Command []string
+}
+
+// Fire implements Action.
+func (a *ExecAction) Fire(ctx context.Context, al Alert) error {
+	payload, err := al.payload()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), "LOGFILTER_ALERT_RULE="+al.Rule)
+	return cmd.Run()
+}
+
+// WebhookAction POSTs the alert as JSON to URL. Responses other than 2xx
+// are errors.
+type WebhookAction struct {
+	URL string
+
+	// Client sends the request; nil means a client with a 10 second timeout.
+	Client *http.Client
+}
+
+// Fire implements Action.
+func (a *WebhookAction) Fire(ctx context.Context, al Alert) error {
+	payload, err := al.payload()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", a.URL, resp.Status)
+	}
+	return nil
+}
+
+// Alerter is a Sink that evaluates rules against every record it receives
+// instead of printing them. Windows and cooldowns are measured in record
+// time, so replaying hours of existing log (as -f does before following,
+// or a plain run over a file) fires only where the log itself had a burst.
+// Records without a timestamp use their arrival time.
+//
+// Actions run on a separate goroutine, one alert after the other, so a
+// slow webhook does not hold up the Filter. Write only blocks when
+// QueueSize alerts are already waiting. Close waits for the queue to be
+// drained.
+type Alerter struct {
+	Rules []*Rule
+
+	// OnError, if set, receives action failures. They never stop the
+	// filter, so a broken webhook does not end the watch.
+	OnError func(rule string, err error)
+
+	// Now returns the current time, used for records without a
+	// timestamp; nil means time.Now.
+	Now func() time.Time
+
+	// Redactor, if set, masks the record sent with each alert. Rules
+	// still match the original record.
+	Redactor *Redactor
+
+	// QueueSize is the number of fired alerts that may wait for their
+	// actions; zero means DefaultAlertQueueSize.
+	QueueSize int
+
+	queue   chan queuedAlert
+	pending sync.WaitGroup
+	done    chan struct{}
+}
+
+// DefaultAlertQueueSize is the Alerter queue size used when QueueSize is 0.
+const DefaultAlertQueueSize = 64
+
+// queuedAlert is a fired alert waiting for the actions of its rule.
+type queuedAlert struct {
+	rule  *Rule
+	alert Alert
+}
+
+// dispatch runs the actions of queued alerts until the queue is closed.
+func (a *Alerter) dispatch() {
+	defer close(a.done)
+	for q := range a.queue {
+		for _, action := range q.rule.Actions {
+			if err := action.Fire(context.Background(), q.alert); err != nil && a.OnError != nil {
+				a.OnError(q.rule.Name, err)
+			}
+		}
+		a.pending.Done()
+	}
+}
+
+// enqueue hands a fired alert to the dispatch goroutine, starting it on
+// first use.
+func (a *Alerter) enqueue(rule *Rule, al Alert) {
+	if a.queue == nil {
+		size := a.QueueSize
+		if size <= 0 {
+			size = DefaultAlertQueueSize
+		}
+		a.queue = make(chan queuedAlert, size)
+		a.done = make(chan struct{})
+		go a.dispatch()
+	}
+	a.pending.Add(1)
+	a.queue <- queuedAlert{rule: rule, alert: al}
+}
+
+// Wait blocks until the actions of every alert fired so far have run.
+func (a *Alerter) Wait() {
+	a.pending.Wait()
+}
+
+// Write implements Sink.
+func (a *Alerter) Write(rec Record) error {
+	if rec.Context {
+		return nil
+	}
+	now := rec.Time
+	if now.IsZero() {
+		now = time.Now()
+		if a.Now != nil {
+			now = a.Now()
+		}
+	}
+	for _, rule := range a.Rules {
+		if !rule.Query.Match(rec) {
+			continue
+		}
+		count, fire := rule.observe(now)
+		if !fire {
+			continue
+		}
+		sent := rec
+		if a.Redactor != nil {
+			sent = a.Redactor.RedactRecord(rec)
+		}
+		al := Alert{Rule: rule.Name, Count: count, Time: now, Record: toJSONRecord(sent)}
+		if rule.Window > 0 {
+			al.Window = rule.Window.String()
+		}
+		a.enqueue(rule, al)
+	}
+	return nil
+}
+
+// Close implements Sink. It returns once the queued alerts have been
+// handled; the Alerter must not be written to afterwards.
+func (a *Alerter) Close() error {
+	if a.queue != nil {
+		close(a.queue)
+		<-a.done
+		a.queue = nil
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package logfilter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAction remembers the alerts it fires.
+type recordingAction struct {
+	alerts []Alert
+}
+
+func (a *recordingAction) Fire(_ context.Context, al Alert) error {
+	a.alerts = append(a.alerts, al)
+	return nil
+}
+
+func newTestRule(t *testing.T, query string, threshold int, window, cooldown time.Duration, action Action) *Rule {
+	t.Helper()
+	return &Rule{Name: "test", Query: mustQuery(t, query), Threshold: threshold, Window: window, Cooldown: cooldown, Actions: []Action{action}}
+}
+
+func TestAlerterThresholdWithoutWindow(t *testing.T) {
+	action := &recordingAction{}
+	alerter := &Alerter{Rules: []*Rule{newTestRule(t, "level >= CRITICAL", 2, 0, 0, action)}}
+	f := &Filter{Sink: alerter}
+	if err := f.Run(strings.NewReader(readLogTxt(t)), "log.txt"); err != nil {
+		t.Fatal(err)
+	}
+	alerter.Close()
+	// log.txt has one EMERGENCY and two CRITICAL lines: more than 2 in total.
+	if len(action.alerts) != 1 || action.alerts[0].Count != 3 {
+		t.Fatalf("alerts = %+v, want one with count 3", action.alerts)
+	}
+}
+
+func TestAlerterWindowUsesRecordTime(t *testing.T) {
+	action := &recordingAction{}
+	alerter := &Alerter{Rules: []*Rule{newTestRule(t, "level = CRITICAL", 1, time.Minute, 0, action)}}
+	f := &Filter{Sink: alerter}
+	// Processed in microseconds, but hours apart in the log: no burst.
+	spread := "2024-05-01 01:00:00 CRITICAL a\n2024-05-01 02:00:00 CRITICAL b\n2024-05-01 03:00:00 CRITICAL c\n"
+	if err := f.Run(strings.NewReader(spread), "spread.log"); err != nil {
+		t.Fatal(err)
+	}
+	alerter.Wait()
+	if len(action.alerts) != 0 {
+		t.Fatalf("spread-out records fired %d alert(s)", len(action.alerts))
+	}
+	burst := "2024-05-01 04:00:00 CRITICAL d\n2024-05-01 04:00:30 CRITICAL e\n"
+	if err := f.Run(strings.NewReader(burst), "burst.log"); err != nil {
+		t.Fatal(err)
+	}
+	alerter.Close()
+	if len(action.alerts) != 1 || action.alerts[0].Record.Message != "e" {
+		t.Fatalf("alerts = %+v, want one for record e", action.alerts)
+	}
+}
+
+func TestWebhookAction(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		bodies   []Alert
+		status   = http.StatusOK
+		ctypeErr string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			ctypeErr = r.Method + " " + r.Header.Get("Content-Type")
+		}
+		data, _ := io.ReadAll(r.Body)
+		var al Alert
+		if err := json.Unmarshal(data, &al); err != nil {
+			t.Errorf("payload %q: %v", data, err)
+		}
+		bodies = append(bodies, al)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	var errs []error
+	alerter := &Alerter{
+		Rules:   []*Rule{newTestRule(t, "level >= ERROR", 1, time.Minute, 10*time.Minute, &WebhookAction{URL: srv.URL})},
+		OnError: func(rule string, err error) { errs = append(errs, err) },
+		Now:     func() time.Time { return now },
+	}
+	// The records carry no timestamp, so the Now hook sets the clock.
+	write := func(level, msg string) {
+		t.Helper()
+		if err := alerter.Write(Record{Level: level, Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ERROR", "one")
+	write("CRITICAL", "two <b>") // second within the window: fires
+	alerter.Wait()
+	mu.Lock()
+	if len(bodies) != 1 {
+		t.Fatalf("webhook called %d times, want 1", len(bodies))
+	}
+	got := bodies[0]
+	mu.Unlock()
+	if got.Rule != "test" || got.Count != 2 || got.Window != "1m0s" || got.Record.Level != "CRITICAL" || got.Record.Message != "two <b>" || !got.Time.Equal(now) {
+		t.Errorf("payload = %+v", got)
+	}
+	if ctypeErr != "" {
+		t.Errorf("request was %s, want POST application/json", ctypeErr)
+	}
+
+	// Within the cooldown further bursts are suppressed.
+	now = now.Add(5 * time.Minute)
+	write("ERROR", "three")
+	write("ERROR", "four")
+	alerter.Wait()
+	mu.Lock()
+	if len(bodies) != 1 {
+		t.Errorf("webhook called %d times during the cooldown, want 1", len(bodies))
+	}
+	status = http.StatusInternalServerError
+	mu.Unlock()
+
+	// After the cooldown the next burst fires; a 500 is reported.
+	now = now.Add(6 * time.Minute)
+	write("ERROR", "five")
+	write("ERROR", "six")
+	alerter.Close()
+	mu.Lock()
+	calls := len(bodies)
+	mu.Unlock()
+	if calls != 2 {
+		t.Errorf("webhook called %d times after the cooldown, want 2", calls)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "500") {
+		t.Errorf("errors = %v, want one 500 error", errs)
+	}
+}
+
+func TestAlerterRedactsOnlyThePayload(t *testing.T) {
+	redactor, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := &recordingAction{}
+	alerter := &Alerter{
+		Rules:    []*Rule{newTestRule(t, "message contains alice@example.com", 0, 0, 0, action)},
+		Redactor: redactor,
+	}
+	f := &Filter{Sink: alerter}
+	if err := f.Run(strings.NewReader("2024-05-01 10:00:00 ERROR login failed for alice@example.com\n"), "app.log"); err != nil {
+		t.Fatal(err)
+	}
+	alerter.Close()
+	// The rule sees the address; the alert does not carry it.
+	if len(action.alerts) != 1 {
+		t.Fatalf("alerts = %+v, want one", action.alerts)
+	}
+	if msg := action.alerts[0].Record.Message; strings.Contains(msg, "alice@example.com") {
+		t.Errorf("alert message = %q, want the address redacted", msg)
+	}
+}
+
+// blockingAction records alerts like recordingAction, but each Fire waits
+// for release to be closed.
+type blockingAction struct {
+	release chan struct{}
+	recordingAction
+}
+
+func (a *blockingAction) Fire(ctx context.Context, al Alert) error {
+	<-a.release
+	return a.recordingAction.Fire(ctx, al)
+}
+
+func TestAlerterDoesNotWaitForActions(t *testing.T) {
+	action := &blockingAction{release: make(chan struct{})}
+	alerter := &Alerter{Rules: []*Rule{newTestRule(t, "level = ERROR", 0, 0, 0, action)}, QueueSize: 4}
+	f := &Filter{Sink: alerter}
+	input := "2024-05-01 10:00:00 ERROR a\n2024-05-01 10:00:01 ERROR b\n2024-05-01 10:00:02 ERROR c\n"
+
+	// The action is stuck, yet the filter gets through its input.
+	ran := make(chan error, 1)
+	go func() { ran <- f.Run(strings.NewReader(input), "app.log") }()
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run waited for a blocked action")
+	}
+
+	close(action.release)
+	alerter.Close()
+	var got []string
+	for _, al := range action.alerts {
+		got = append(got, al.Record.Message)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("alerts for %q, want %q in order", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package logfilter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"text/tabwriter"
+)
+
+// templateRules replace variable tokens in a message with placeholders, so
+// "Failed to find user with ID '42'" and "Failed to find user with ID '7'"
+// share the template "Failed to find user with ID <STR>". The rules are
+// applied in order, so more specific patterns such as IP addresses are
+// replaced before the generic number rule sees their digits. A rule with
+// valid only replaces the matches valid accepts.
+var templateRules = []struct {
+	re          *regexp.Regexp
+	placeholder string
+	valid       func(match string) bool
+}{
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<STR>", nil},
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`), "<EMAIL>", nil},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<UUID>", nil},
+	// IPv6 addresses are found like the redaction rule does, so C++ names
+	// and times are left alone.
+	{ipv6Candidate, "<IP>", isIPv6},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<IP>", nil},
+	{regexp.MustCompile(`\b\d+(\.\d+)?\b`), "<NUM>", nil},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]{8,}\b`), "<HEX>", nil},
+}
+
+// Template normalises a message by replacing quoted strings, e-mail
+// addresses, UUIDs, IPv4 and IPv6 addresses, numbers and hexadecimal IDs
+// with placeholders.
+func Template(message string) string {
+	for _, rule := range templateRules {
+		if rule.valid == nil {
+			message = rule.re.ReplaceAllString(message, rule.placeholder)
+			continue
+		}
+		message = rule.re.ReplaceAllStringFunc(message, func(m string) string {
+			if rule.valid(m) {
+				return rule.placeholder
+			}
+			return m
+		})
+	}
+	return message
+}
+
+// Cluster is one message template with the number of records that
+// produced it and the first of them as an example.
+type Cluster struct {
+	Template string `json:"template"`
+	Count    int    `json:"count"`
+	Example  string `json:"example"`
+	Level    string `json:"level"`
+}
+
+// Clusterer groups records by message template.
+type Clusterer struct {
+	byTemplate map[string]*Cluster
+	order      []*Cluster // first-seen order, used to break ties
+}
+
+// NewClusterer returns an empty Clusterer.
+func NewClusterer() *Clusterer {
+	return &Clusterer{byTemplate: map[string]*Cluster{}}
+}
+
+// Add assigns the record to its cluster. Templates are kept per level, so
+// the same text logged at INFO and at ERROR stays in separate clusters.
+func (c *Clusterer) Add(rec Record) {
+	tmpl := Template(rec.Message)
+	key := rec.Level + " " + tmpl
+	cl, ok := c.byTemplate[key]
+	if !ok {
+		cl = &Cluster{Template: tmpl, Example: rec.Raw, Level: rec.Level}
+		c.byTemplate[key] = cl
+		c.order = append(c.order, cl)
+	}
+	cl.Count++
+}
+
+// Write implements Sink by adding the record to its cluster.
+func (c *Clusterer) Write(rec Record) error {
+	c.Add(rec)
+	return nil
+}
+
+// Close implements Sink. The clusters are printed separately with
+// WriteText or WriteJSON.
+func (c *Clusterer) Close() error { return nil }
+
+// Clusters returns all clusters, most frequent first. Clusters with the
+// same count keep the order in which they first appeared.
+func (c *Clusterer) Clusters() []Cluster {
+	out := make([]Cluster, len(c.order))
+	for i, cl := range c.order {
+		out[i] = *cl
+	}
+	slices.SortStableFunc(out, func(a, b Cluster) int { return cmp.Compare(b.Count, a.Count) })
+	return out
+}
+
+// WriteText prints one cluster per entry: the count, level and template on
+// the first line and an example line below it.
+func (c *Clusterer) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cl := range c.Clusters() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", cl.Count, cl.Level, cl.Template)
+		fmt.Fprintf(tw, "\t\te.g. %s\n", cl.Example)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON prints the clusters as a JSON array.
+func (c *Clusterer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(c.Clusters())
+}
@@ -0,0 +1,38 @@
+package logfilter
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Failed to find user with ID '42'", "Failed to find user with ID <STR>"},
+		{"request 17 took 350ms", "request <NUM> took 350ms"},
+		{"mail to bob@example.com bounced", "mail to <EMAIL> bounced"},
+		{"job 123e4567-e89b-12d3-a456-426614174000 done", "job <UUID> done"},
+		{"client 10.0.0.12:5432 connected", "client <IP> connected"},
+		{"client 2001:db8::1 connected", "client <IP> connected"},
+		{"client fe80::1ff:fe23:4567:890a connected", "client <IP> connected"},
+		{"peer [2001:db8::7]:443 reset", "peer [<IP>]:<NUM> reset"},
+		{"mapped ::ffff:192.0.2.128 seen", "mapped <IP> seen"},
+		{"panic in std::vector::at", "panic in std::vector::at"},
+		{"started at 02:00:00", "started at <NUM>:<NUM>:<NUM>"},
+		{"object 0xdeadbeef freed", "object <HEX> freed"},
+	}
+	for _, tt := range tests {
+		if got := Template(tt.in); got != tt.want {
+			t.Errorf("Template(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClustererIPv6(t *testing.T) {
+	c := NewClusterer()
+	for _, msg := range []string{"login from 2001:db8::1", "login from 2001:db8::2", "login from 10.0.0.1", "login from fe80::a:b"} {
+		c.Add(Record{Level: "INFO", Message: msg, Raw: msg})
+	}
+	clusters := c.Clusters()
+	if len(clusters) != 1 || clusters[0].Count != 4 || clusters[0].Template != "login from <IP>" {
+		t.Errorf("clusters = %+v, want one login from <IP> with 4 records", clusters)
+	}
+}
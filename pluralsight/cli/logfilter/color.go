@@ -0,0 +1,107 @@
+package logfilter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ANSI escape sequences used for terminal output.
+const (
+	ansiReset     = "\x1b[0m"
+	ansiDim       = "\x1b[2m"
+	ansiRed       = "\x1b[31m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiBlue      = "\x1b[34m"
+	ansiMagenta   = "\x1b[35m"
+	ansiCyan      = "\x1b[36m"
+	ansiBoldRed   = "\x1b[1;31m"
+	ansiHighlight = "\x1b[1;4m" // bold and underlined, visible on any level colour
+)
+
+// levelColors maps each severity to the colour of its level column.
+var levelColors = map[Severity]string{
+	SeverityDebug:     ansiDim,
+	SeverityInfo:      ansiGreen,
+	SeverityNotice:    ansiCyan,
+	SeverityWarning:   ansiYellow,
+	SeverityError:     ansiRed,
+	SeverityCritical:  ansiBoldRed,
+	SeverityAlert:     ansiBoldRed,
+	SeverityEmergency: ansiBoldRed,
+}
+
+// levelWidth is the width of the level column, the length of "EMERGENCY".
+const levelWidth = len("EMERGENCY")
+
+// colorize wraps s in the given escape sequence.
+func colorize(s, color string) string {
+	if color == "" || s == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// levelColor returns the colour of a level name, or "" for unknown levels.
+func levelColor(level string) string {
+	sev, err := ParseSeverity(level)
+	if err != nil {
+		return ""
+	}
+	return levelColors[sev]
+}
+
+// paddedLevel returns level coloured and padded to the level column.
+func paddedLevel(level string) string {
+	pad := ""
+	if n := levelWidth - len(level); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	return colorize(level, levelColor(level)) + pad
+}
+
+// highlight marks every match of re in s. A nil re leaves s unchanged.
+func highlight(s string, re *regexp.Regexp) string {
+	if re == nil {
+		return s
+	}
+	return re.ReplaceAllStringFunc(s, func(m string) string { return colorize(m, ansiHighlight) })
+}
+
+// sourceColor is the colour of file names, as in grep --color.
+const sourceColor = ansiMagenta
+
+// separatorColor is the colour of the "--" line between context groups.
+const separatorColor = ansiBlue
+
+// ColorModes lists the modes accepted by ColorEnabled.
+var ColorModes = []string{"auto", "always", "never"}
+
+// ColorEnabled reports whether output to w should be coloured in the given
+// mode. "auto" colours only when w is a terminal and neither NO_COLOR (see
+// https://no-color.org) nor TERM=dumb asks otherwise.
+func ColorEnabled(mode string, w io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto", "":
+	default:
+		return false, fmt.Errorf("unknown colour mode %q (want one of %s)", mode, strings.Join(ColorModes, ", "))
+	}
+	// Any non-empty NO_COLOR disables colour.
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false, nil
+	}
+	// A terminal is a character device; pipes and regular files are not.
+	f, ok := w.(*os.File)
+	if !ok {
+		return false, nil
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+}
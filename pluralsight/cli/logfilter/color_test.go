@@ -0,0 +1,167 @@
+package logfilter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestColorEnabled(t *testing.T) {
+	// /dev/null is a character device, so "auto" treats it like a terminal.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer devNull.Close()
+	regular, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer regular.Close()
+
+	tests := []struct {
+		name    string
+		mode    string
+		w       io.Writer
+		noColor string // "" leaves NO_COLOR unset
+		term    string
+		want    bool
+	}{
+		{"auto on a terminal", "auto", devNull, "", "xterm", true},
+		{"empty mode is auto", "", devNull, "", "xterm", true},
+		{"auto with NO_COLOR", "auto", devNull, "1", "xterm", false},
+		{"auto with TERM=dumb", "auto", devNull, "", "dumb", false},
+		{"auto to a file", "auto", regular, "", "xterm", false},
+		{"auto to a buffer", "auto", &bytes.Buffer{}, "", "xterm", false},
+		{"always to a buffer", "always", &bytes.Buffer{}, "", "xterm", true},
+		{"always beats NO_COLOR", "always", devNull, "1", "dumb", true},
+		{"never on a terminal", "never", devNull, "", "xterm", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TERM", tt.term)
+			t.Setenv("NO_COLOR", tt.noColor)
+			if tt.noColor == "" {
+				os.Unsetenv("NO_COLOR")
+			}
+			got, err := ColorEnabled(tt.mode, tt.w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ColorEnabled(%q) = %v, want %v", tt.mode, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ColorEnabled("sometimes", devNull); err == nil || !strings.Contains(err.Error(), "auto, always, never") {
+		t.Errorf("unknown mode: error = %v", err)
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rec := func(level, msg string) Record {
+		return Record{Time: at, Level: level, Message: msg, Raw: "raw " + msg, Source: "app.log"}
+	}
+	const ts = ansiDim + "2024-05-01 10:00:00" + ansiReset
+	tests := []struct {
+		name   string
+		opts   SinkOptions
+		record Record
+		want   string
+	}{
+		{"plain text keeps the raw line", SinkOptions{}, rec("ERROR", "boom"), "raw boom\n"},
+		{"error", SinkOptions{Color: true}, rec("ERROR", "boom"), ts + " " + ansiRed + "ERROR" + ansiReset + "     boom\n"},
+		{"critical", SinkOptions{Color: true}, rec("CRITICAL", "boom"), ts + " " + ansiBoldRed + "CRITICAL" + ansiReset + "  boom\n"},
+		{"info", SinkOptions{Color: true}, rec("INFO", "ok"), ts + " " + ansiGreen + "INFO" + ansiReset + "      ok\n"},
+		{"unknown level is padded, not coloured", SinkOptions{Color: true}, rec("AUDIT", "x"), ts + " AUDIT     x\n"},
+		{"time layout", SinkOptions{Color: true, TimeLayout: time.Kitchen}, rec("INFO", "ok"), ansiDim + "10:00AM" + ansiReset + " " + ansiGreen + "INFO" + ansiReset + "      ok\n"},
+		{"unparsed line is dimmed", SinkOptions{Color: true}, Record{Raw: "garbage"}, ansiDim + "garbage" + ansiReset + "\n"},
+		{
+			"context is dimmed as a whole",
+			SinkOptions{Color: true, Highlight: regexp.MustCompile("boom")},
+			Record{Time: at, Level: "ERROR", Message: "boom", Context: true},
+			ansiDim + "2024-05-01 10:00:00 ERROR     boom" + ansiReset + "\n",
+		},
+		{
+			"highlight",
+			SinkOptions{Color: true, Highlight: regexp.MustCompile("o+")},
+			rec("ERROR", "boom at foo"),
+			ts + " " + ansiRed + "ERROR" + ansiReset + "     b" + ansiHighlight + "oo" + ansiReset + "m at f" + ansiHighlight + "oo" + ansiReset + "\n",
+		},
+		{"highlight needs colour", SinkOptions{Highlight: regexp.MustCompile("o+")}, rec("ERROR", "boom"), "raw boom\n"},
+		{"source", SinkOptions{Color: true, WithSource: true}, rec("INFO", "ok"), ansiMagenta + "app.log" + ansiReset + ":" + ts + " " + ansiGreen + "INFO" + ansiReset + "      ok\n"},
+		{
+			"fields",
+			SinkOptions{Color: true, Fields: []string{"level", "message", "source"}, Highlight: regexp.MustCompile("boom")},
+			rec("WARNING", "boom"),
+			ansiYellow + "WARNING" + ansiReset + "\t" + ansiHighlight + "boom" + ansiReset + "\tapp.log\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sink, err := NewSink("text", &buf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Write(tt.record); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("output = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestColorSeparator(t *testing.T) {
+	for _, tt := range []struct {
+		color bool
+		want  string
+	}{
+		{false, "--\n"},
+		{true, ansiBlue + "--" + ansiReset + "\n"},
+	} {
+		var buf bytes.Buffer
+		sink, err := NewSink("text", &buf, SinkOptions{Color: tt.color})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.(Separator).WriteSeparator(); err != nil {
+			t.Fatal(err)
+		}
+		sink.Close()
+		if buf.String() != tt.want {
+			t.Errorf("colour %v: separator = %q, want %q", tt.color, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestPipelineColor(t *testing.T) {
+	// Through a Pipeline, NO_COLOR only matters in auto mode, and a buffer
+	// is never a terminal.
+	t.Setenv("NO_COLOR", "1")
+	for _, tt := range []struct {
+		mode string
+		want bool
+	}{
+		{"auto", false},
+		{"never", false},
+		{"always", true},
+	} {
+		got, _, _ := runPipeline(t, Options{Level: "EMERGENCY", Color: tt.mode})
+		if colored := strings.Contains(got, "\x1b["); colored != tt.want {
+			t.Errorf("-color %s: output %q, want colour %v", tt.mode, got, tt.want)
+		}
+	}
+}
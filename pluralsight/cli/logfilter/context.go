@@ -0,0 +1,114 @@
+package logfilter
+
+// Separator is implemented by sinks that can mark a gap between two groups
+// of context lines, like the "--" line printed by grep.
+type Separator interface {
+	WriteSeparator() error
+}
+
+// contextRing is a fixed-size ring buffer holding the most recent
+// non-matching records, so memory stays bounded by the -B value no matter
+// how long the run of non-matching lines is.
+type contextRing struct {
+	buf   []Record
+	start int // index of the oldest record
+	n     int // number of records held
+}
+
+func newContextRing(size int) *contextRing {
+	return &contextRing{buf: make([]Record, size)}
+}
+
+// push appends rec, overwriting the oldest record when the ring is full.
+func (r *contextRing) push(rec Record) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = rec
+		r.n++
+		return
+	}
+	r.buf[r.start] = rec
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// drain calls fn for every held record, oldest first, and empties the ring.
+func (r *contextRing) drain(fn func(Record) error) error {
+	for i := 0; i < r.n; i++ {
+		if err := fn(r.buf[(r.start+i)%len(r.buf)]); err != nil {
+			return err
+		}
+	}
+	r.start, r.n = 0, 0
+	return nil
+}
+
+// contextState tracks context lines for one input.
+type contextState struct {
+	ring        *contextRing
+	line        int // number of lines seen so far
+	lastPrinted int // line number of the last printed line, 0 if none
+	after       int // after-context lines still to print
+}
+
+// recordWithContext is the context-aware counterpart of record: besides
+// matches it prints up to Before records preceding each match and After
+// records following it, with a separator between non-contiguous groups.
+// matched is false for lines rejected by the matchers. The caller holds f.mu.
+func (f *Filter) recordWithContext(res lineResult, matched bool, flush bool) error {
+	if f.contexts == nil {
+		f.contexts = map[string]*contextState{}
+	}
+	source := res.rec.Source
+	st, ok := f.contexts[source]
+	if !ok {
+		st = &contextState{ring: newContextRing(f.Before)}
+		f.contexts[source] = st
+	}
+	st.line++
+
+	if res.err != nil {
+		// Unparsable lines are counted as usual but may still appear as context.
+		f.invalid++
+		if f.OnInvalid != nil {
+			f.OnInvalid(res.rec, res.err)
+		}
+		matched = false
+	}
+
+	if !matched {
+		if st.after > 0 {
+			st.after--
+			st.lastPrinted = st.line
+			return f.writeContext(res.rec, flush)
+		}
+		st.ring.push(res.rec)
+		return nil
+	}
+
+	// A new group starts here unless it directly continues the previous one
+	// from the same input.
+	first := st.line - st.ring.n
+	if f.printedAny && (source != f.lastSource || first > st.lastPrinted+1) {
+		if sep, ok := f.Sink.(Separator); ok {
+			if err := sep.WriteSeparator(); err != nil {
+				return err
+			}
+		}
+	}
+	f.printedAny, f.lastSource = true, source
+
+	if err := st.ring.drain(func(rec Record) error { return f.writeContext(rec, false) }); err != nil {
+		return err
+	}
+	st.after = f.After
+	st.lastPrinted = st.line
+	return f.record(res, flush)
+}
+
+// writeContext writes rec marked as a context line.
+func (f *Filter) writeContext(rec Record, flush bool) error {
+	rec.Context = true
+	return f.record(lineResult{rec: rec}, flush)
+}
@@ -0,0 +1,193 @@
+package logfilter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// contextSink records what a Filter with context writes: "=msg" for
+// matches, "-msg" for context records and "--" for separators.
+type contextSink struct {
+	out []string
+}
+
+func (s *contextSink) Write(rec Record) error {
+	mark := "="
+	if rec.Context {
+		mark = "-"
+	}
+	msg := rec.Message
+	if msg == "" {
+		msg = rec.Raw // unparsable lines
+	}
+	s.out = append(s.out, mark+msg)
+	return nil
+}
+
+func (s *contextSink) WriteSeparator() error {
+	s.out = append(s.out, "--")
+	return nil
+}
+
+func (s *contextSink) Close() error { return nil }
+
+// contextInput returns one line per level, with the messages prefix1,
+// prefix2 and so on. A level of "?" gives an unparsable line.
+func contextInput(prefix string, levels ...string) string {
+	var b strings.Builder
+	for i, level := range levels {
+		if level == "?" {
+			fmt.Fprintf(&b, "garbage %s%d\n", prefix, i+1)
+			continue
+		}
+		fmt.Fprintf(&b, "2024-05-01 00:00:%02d %s %s%d\n", i, level, prefix, i+1)
+	}
+	return b.String()
+}
+
+func TestFilterContext(t *testing.T) {
+	type input struct {
+		source string
+		text   string
+	}
+	tests := []struct {
+		name          string
+		before, after int
+		inputs        []input
+		want          []string
+	}{
+		{
+			name:   "ring keeps only the last lines before a match",
+			before: 2,
+			inputs: []input{{"a.log", contextInput("a", "INFO", "INFO", "INFO", "INFO", "ERROR")}},
+			want:   []string{"-a3", "-a4", "=a5"},
+		},
+		{
+			name:   "after context stops at the count",
+			after:  2,
+			inputs: []input{{"a.log", contextInput("a", "ERROR", "INFO", "INFO", "INFO")}},
+			want:   []string{"=a1", "-a2", "-a3"},
+		},
+		{
+			name:   "a separator between groups with a gap",
+			before: 1, after: 1,
+			inputs: []input{{"a.log", contextInput("a", "ERROR", "INFO", "INFO", "INFO", "ERROR")}},
+			want:   []string{"=a1", "-a2", "--", "-a4", "=a5"},
+		},
+		{
+			name:   "adjacent groups are joined",
+			before: 1, after: 1,
+			inputs: []input{{"a.log", contextInput("a", "ERROR", "INFO", "INFO", "ERROR")}},
+			want:   []string{"=a1", "-a2", "-a3", "=a4"},
+		},
+		{
+			name:   "a match inside the after context extends it",
+			after:  2,
+			inputs: []input{{"a.log", contextInput("a", "ERROR", "INFO", "ERROR", "INFO", "INFO", "INFO")}},
+			want:   []string{"=a1", "-a2", "=a3", "-a4", "-a5"},
+		},
+		{
+			name:   "context lines are not printed twice",
+			before: 3, after: 3,
+			inputs: []input{{"a.log", contextInput("a", "INFO", "ERROR", "INFO", "ERROR", "INFO")}},
+			want:   []string{"-a1", "=a2", "-a3", "=a4", "-a5"},
+		},
+		{
+			name:   "unparsable lines can be context",
+			before: 1, after: 1,
+			inputs: []input{{"a.log", contextInput("a", "?", "ERROR", "?")}},
+			want:   []string{"-garbage a1", "=a2", "-garbage a3"},
+		},
+		{
+			name:   "sources are separated even when both groups touch",
+			before: 1, after: 1,
+			inputs: []input{
+				{"a.log", contextInput("a", "INFO", "ERROR")},
+				{"b.log", contextInput("b", "ERROR", "INFO")},
+			},
+			want: []string{"-a1", "=a2", "--", "=b1", "-b2"},
+		},
+		{
+			name:   "the ring of one source does not leak into the next",
+			before: 2,
+			inputs: []input{
+				{"a.log", contextInput("a", "INFO", "INFO")},
+				{"b.log", contextInput("b", "ERROR")},
+			},
+			want: []string{"=b1"},
+		},
+		{
+			name:  "after context does not carry over to the next source",
+			after: 2,
+			inputs: []input{
+				{"a.log", contextInput("a", "INFO", "ERROR")},
+				{"b.log", contextInput("b", "INFO", "INFO")},
+			},
+			want: []string{"=a2"},
+		},
+		{
+			name:   "no separator before the first group",
+			before: 1,
+			inputs: []input{
+				{"a.log", contextInput("a", "INFO", "INFO")},
+				{"b.log", contextInput("b", "INFO", "ERROR")},
+			},
+			want: []string{"-b1", "=b2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &contextSink{}
+			f := &Filter{
+				Matchers: []Matcher{LevelIs("ERROR")},
+				Sink:     sink,
+				Before:   tt.before,
+				After:    tt.after,
+			}
+			for _, in := range tt.inputs {
+				if err := f.Run(strings.NewReader(in.text), in.source); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(sink.out, tt.want) {
+				t.Errorf("output = %q, want %q", sink.out, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextRing(t *testing.T) {
+	r := newContextRing(3)
+	for i := 1; i <= 7; i++ {
+		r.push(Record{Message: fmt.Sprint(i)})
+	}
+	var got []string
+	drain := func() {
+		got = nil
+		r.drain(func(rec Record) error {
+			got = append(got, rec.Message)
+			return nil
+		})
+	}
+	drain()
+	if want := []string{"5", "6", "7"}; !slices.Equal(got, want) {
+		t.Errorf("ring held %q, want %q", got, want)
+	}
+	drain()
+	if got != nil {
+		t.Errorf("drained ring still held %q", got)
+	}
+	r.push(Record{Message: "8"})
+	drain()
+	if want := []string{"8"}; !slices.Equal(got, want) {
+		t.Errorf("ring held %q after reuse, want %q", got, want)
+	}
+
+	empty := newContextRing(0)
+	empty.push(Record{Message: "x"})
+	if empty.n != 0 {
+		t.Errorf("a zero-size ring held %d records", empty.n)
+	}
+}
@@ -0,0 +1,82 @@
+package logfilter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic numbers at the start of each supported compressed format.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// IsCompressed reports whether head, the first bytes of a stream, starts
+// with the signature of one of the formats Decompress understands.
+func IsCompressed(head []byte) bool {
+	return bytes.HasPrefix(head, gzipMagic) || isBzip2(head) || bytes.HasPrefix(head, zstdMagic)
+}
+
+// isBzip2 reports whether head starts with a bzip2 header: "BZh" followed
+// by the block size digit 1-9. The digit keeps plain text that happens to
+// start with "BZh" from being taken for bzip2.
+func isBzip2(head []byte) bool {
+	return len(head) > len(bzip2Magic) && bytes.HasPrefix(head, bzip2Magic) &&
+		head[len(bzip2Magic)] >= '1' && head[len(bzip2Magic)] <= '9'
+}
+
+// Decompress inspects the first bytes of r and, if they carry a gzip, bzip2
+// or zstd signature, returns a reader producing the decompressed stream.
+// Anything else is returned unchanged, so callers can pass plain and
+// compressed files through the same code path. File names are not
+// consulted: rotated logs such as "app.log.1" are often compressed without
+// a matching extension.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	// Peek returns the next bytes without consuming them, so the chosen decoder
+	// (or the caller, for plain text) still sees the whole stream. A short or
+	// empty input simply returns fewer bytes together with an error that can be ignored.
+	head, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		// gzip.Reader handles multi-member files such as those produced by
+		// concatenating several .gz files.
+		return gzip.NewReader(br)
+	case isBzip2(head):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(head, zstdMagic):
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// readCloser pairs a decompressing reader with the file underneath it, so
+// closing the pair releases both.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes every underlying closer and returns the first error.
+func (rc *readCloser) Close() error {
+	var first error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
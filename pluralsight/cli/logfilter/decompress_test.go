@@ -0,0 +1,126 @@
+package logfilter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const sampleText = "hello\nworld\n"
+
+// sampleBzip2 is sampleText compressed with bzip2(1); the standard library
+// can only read bzip2.
+var sampleBzip2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x6b, 0x5f,
+	0xb1, 0xdd, 0x00, 0x00, 0x02, 0x41, 0x80, 0x00, 0x10, 0x06, 0x44, 0x90,
+	0x80, 0x20, 0x00, 0x31, 0x0c, 0x08, 0x21, 0xa3, 0x69, 0x08, 0x07, 0x23,
+	0xae, 0x87, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48, 0x35, 0xaf, 0xd8, 0xee,
+	0x80,
+}
+
+func gzipBytes(t *testing.T, text string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(w, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, text string) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll([]byte(text), nil)
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      []byte
+		compressed bool
+		want       string
+	}{
+		{"plain", []byte(sampleText), false, sampleText},
+		{"empty", nil, false, ""},
+		{"shorter than any magic", []byte("a"), false, "a"},
+		{"gzip", gzipBytes(t, sampleText), true, sampleText},
+		// Concatenated .gz files are read as one stream.
+		{"multi-member gzip", append(gzipBytes(t, "hello\n"), gzipBytes(t, "world\n")...), true, sampleText},
+		{"bzip2", sampleBzip2, true, sampleText},
+		{"zstd", zstdBytes(t, sampleText), true, sampleText},
+		// Text starting with "BZh" but no block size digit is not bzip2.
+		{"BZh text", []byte("BZh, said the log\n"), false, "BZh, said the log\n"},
+		{"BZh0 text", []byte("BZh0\n"), false, "BZh0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsCompressed(tt.input); got != tt.compressed {
+				t.Errorf("IsCompressed = %v, want %v", got, tt.compressed)
+			}
+			rc, err := Decompress(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Decompress = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressCorruptGzip(t *testing.T) {
+	data := gzipBytes(t, sampleText)
+	data = data[:len(data)-4] // drop part of the trailer
+	rc, err := Decompress(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Error("reading a truncated gzip stream succeeded")
+	}
+}
+
+func TestOpenInputIgnoresExtension(t *testing.T) {
+	// Rotated logs are often compressed without a matching extension, and
+	// some plain files carry one.
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"app.log.1":  gzipBytes(t, sampleText),
+		"app.log.gz": []byte(sampleText),
+		"app.log.2":  zstdBytes(t, sampleText),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := OpenInput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != sampleText {
+			t.Errorf("%s: read %q, %v; want %q", name, got, err, sampleText)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package logfilter
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DedupeSink wraps a Sink and suppresses repeated records, the way syslog
+// does. Two records repeat each other when they come from the same input
+// with the same level and message; their times may differ.
+//
+// With a zero Window only consecutive repeats are suppressed, and the next
+// different record is preceded by a "last message repeated N times"
+// record. With a Window, a record is suppressed when an identical one was
+// written less than Window earlier (by record time), even with other
+// records in between; once the window has passed, a "message repeated N
+// times: [...]" record reports how many were dropped.
+//
+// Summary records have the level and source of the repeated record, the
+// time of its last repeat and the count in the field "repeated".
+type DedupeSink struct {
+	Sink   Sink
+	Window time.Duration
+
+	last    *dedupeEntry            // consecutive mode
+	entries map[string]*dedupeEntry // window mode
+}
+
+// dedupeEntry tracks one written record and its suppressed repeats.
+type dedupeEntry struct {
+	key   string
+	first time.Time // time of the written record
+	rec   Record    // last repeat
+	count int       // suppressed repeats
+}
+
+func dedupeKey(rec Record) string {
+	return rec.Source + "\x00" + rec.Level + "\x00" + rec.Message
+}
+
+// Write implements Sink.
+func (s *DedupeSink) Write(rec Record) error {
+	// Context lines are printed as they are; they also end a run of
+	// consecutive repeats.
+	if rec.Context {
+		if err := s.flushLast(); err != nil {
+			return err
+		}
+		return s.Sink.Write(rec)
+	}
+	if s.Window > 0 {
+		return s.writeWindowed(rec)
+	}
+
+	key := dedupeKey(rec)
+	if s.last != nil && s.last.key == key {
+		s.last.rec = rec
+		s.last.count++
+		return nil
+	}
+	if err := s.flushLast(); err != nil {
+		return err
+	}
+	s.last = &dedupeEntry{key: key, first: rec.Time, rec: rec}
+	return s.Sink.Write(rec)
+}
+
+func (s *DedupeSink) writeWindowed(rec Record) error {
+	if s.entries == nil {
+		s.entries = map[string]*dedupeEntry{}
+	}
+	if err := s.expire(rec.Time, false); err != nil {
+		return err
+	}
+	key := dedupeKey(rec)
+	if e, ok := s.entries[key]; ok {
+		e.rec = rec
+		e.count++
+		return nil
+	}
+	s.entries[key] = &dedupeEntry{key: key, first: rec.Time, rec: rec}
+	return s.Sink.Write(rec)
+}
+
+// expire reports and forgets the entries whose window has passed at now,
+// or all of them, oldest first.
+func (s *DedupeSink) expire(now time.Time, all bool) error {
+	var done []*dedupeEntry
+	for key, e := range s.entries {
+		if all || now.Sub(e.first) >= s.Window {
+			done = append(done, e)
+			delete(s.entries, key)
+		}
+	}
+	slices.SortFunc(done, func(a, b *dedupeEntry) int { return a.first.Compare(b.first) })
+	for _, e := range done {
+		if e.count == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("message repeated %d times: [%s]", e.count, e.rec.Message)
+		if err := s.Sink.Write(repeatedRecord(e, msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushLast writes the summary of the current run of consecutive repeats.
+func (s *DedupeSink) flushLast() error {
+	e := s.last
+	s.last = nil
+	if e == nil || e.count == 0 {
+		return nil
+	}
+	return s.Sink.Write(repeatedRecord(e, fmt.Sprintf("last message repeated %d times", e.count)))
+}
+
+// repeatedRecord builds the summary record for e.
+func repeatedRecord(e *dedupeEntry, msg string) Record {
+	return Record{
+		Time:    e.rec.Time,
+		Level:   e.rec.Level,
+		Message: msg,
+		Raw:     msg,
+		Source:  e.rec.Source,
+		Fields:  map[string]string{"repeated": strconv.Itoa(e.count)},
+	}
+}
+
+// Close writes the outstanding summaries and closes the wrapped sink.
+func (s *DedupeSink) Close() error {
+	err := s.flushLast()
+	if err == nil {
+		err = s.expire(time.Time{}, true)
+	}
+	if cerr := s.Sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush implements Flusher when the wrapped sink does. Pending summaries
+// are kept, since more repeats may follow.
+func (s *DedupeSink) Flush() error {
+	if f, ok := s.Sink.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// WriteSeparator implements Separator when the wrapped sink does. A gap
+// between context groups ends a run of consecutive repeats.
+func (s *DedupeSink) WriteSeparator() error {
+	if err := s.flushLast(); err != nil {
+		return err
+	}
+	if sep, ok := s.Sink.(Separator); ok {
+		return sep.WriteSeparator()
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package logfilter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupeSink(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// at builds a record from "SECONDS LEVEL message", e.g. "5 INFO a";
+	// a "-" prefix makes it a context record, a "b.log:" prefix sets the
+	// source.
+	at := func(spec string) Record {
+		rec := Record{Source: "a.log"}
+		if s, ok := strings.CutPrefix(spec, "-"); ok {
+			spec, rec.Context = s, true
+		}
+		if src, s, ok := strings.Cut(spec, ":"); ok {
+			rec.Source, spec = src, s
+		}
+		var secs int
+		fmt.Sscanf(spec, "%d %s", &secs, &rec.Level)
+		_, rec.Message, _ = strings.Cut(spec[strings.Index(spec, " ")+1:], " ")
+		rec.Time = start.Add(time.Duration(secs) * time.Second)
+		return rec
+	}
+	// Written records are listed as "SECONDS LEVEL message", with the
+	// source when it is not a.log and the repeat count of summaries.
+	show := func(rec Record) string {
+		s := fmt.Sprintf("%d %s %s", int(rec.Time.Sub(start).Seconds()), rec.Level, rec.Message)
+		if rec.Source != "a.log" {
+			s = rec.Source + ":" + s
+		}
+		if n, ok := rec.Fields["repeated"]; ok {
+			s += " [repeated=" + n + "]"
+		}
+		return s
+	}
+	tests := []struct {
+		name    string
+		window  time.Duration
+		records []string
+		want    []string
+	}{
+		{
+			name:    "no repeats",
+			records: []string{"0 INFO a", "1 INFO b", "2 INFO a"},
+			want:    []string{"0 INFO a", "1 INFO b", "2 INFO a"},
+		},
+		{
+			name:    "consecutive repeats",
+			records: []string{"0 INFO a", "1 INFO a", "2 INFO a", "3 INFO b"},
+			want:    []string{"0 INFO a", "2 INFO last message repeated 2 times [repeated=2]", "3 INFO b"},
+		},
+		{
+			name:    "repeats at the end are reported on Close",
+			records: []string{"0 ERROR boom", "5 ERROR boom"},
+			want:    []string{"0 ERROR boom", "5 ERROR last message repeated 1 times [repeated=1]"},
+		},
+		{
+			name:    "another level is no repeat",
+			records: []string{"0 INFO a", "1 ERROR a", "2 ERROR a"},
+			want:    []string{"0 INFO a", "1 ERROR a", "2 ERROR last message repeated 1 times [repeated=1]"},
+		},
+		{
+			name:    "another source is no repeat",
+			records: []string{"0 INFO a", "b.log:1 INFO a", "b.log:2 INFO a"},
+			want:    []string{"0 INFO a", "b.log:1 INFO a", "b.log:2 INFO last message repeated 1 times [repeated=1]"},
+		},
+		{
+			name:    "context ends a run",
+			records: []string{"0 INFO a", "1 INFO a", "-2 DEBUG ctx", "3 INFO a"},
+			want:    []string{"0 INFO a", "1 INFO last message repeated 1 times [repeated=1]", "2 DEBUG ctx", "3 INFO a"},
+		},
+		{
+			name:    "window: repeats with other records between",
+			window:  time.Minute,
+			records: []string{"0 INFO a", "1 INFO b", "2 INFO a", "3 INFO a"},
+			want:    []string{"0 INFO a", "1 INFO b", "3 INFO message repeated 2 times: [a] [repeated=2]"},
+		},
+		{
+			name:    "window: reported once the window has passed",
+			window:  time.Minute,
+			records: []string{"0 INFO a", "10 INFO a", "20 INFO a", "70 INFO b"},
+			want:    []string{"0 INFO a", "20 INFO message repeated 2 times: [a] [repeated=2]", "70 INFO b"},
+		},
+		{
+			name:    "window: a repeat after the window is written again",
+			window:  time.Minute,
+			records: []string{"0 INFO a", "90 INFO a"},
+			want:    []string{"0 INFO a", "90 INFO a"},
+		},
+		{
+			name:    "window: summaries on Close are oldest first",
+			window:  time.Minute,
+			records: []string{"0 INFO a", "1 WARNING b", "2 WARNING b", "3 INFO a"},
+			want:    []string{"0 INFO a", "1 WARNING b", "3 INFO message repeated 1 times: [a] [repeated=1]", "2 WARNING message repeated 1 times: [b] [repeated=1]"},
+		},
+		{
+			name:    "window: context passes through",
+			window:  time.Minute,
+			records: []string{"0 INFO a", "-1 DEBUG ctx", "2 INFO a"},
+			want:    []string{"0 INFO a", "1 DEBUG ctx", "2 INFO message repeated 1 times: [a] [repeated=1]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &recordSink{}
+			sink := &DedupeSink{Sink: inner, Window: tt.window}
+			for _, spec := range tt.records {
+				if err := sink.Write(at(spec)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range inner.records {
+				got = append(got, show(rec))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("written:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDedupeSummaryText(t *testing.T) {
+	// Summaries print like any other record: the text output shows the
+	// summary line, since it has no original line of its own.
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   string
+	}{
+		{"consecutive", 0, "2024-05-01 10:00:00 INFO disk full\nlast message repeated 2 times\n"},
+		{"windowed", time.Minute, "2024-05-01 10:00:00 INFO disk full\nmessage repeated 2 times: [disk full]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			text, err := NewSink("text", &out, SinkOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &Filter{Sink: &DedupeSink{Sink: text, Window: tt.window}}
+			input := strings.Repeat("2024-05-01 10:00:00 INFO disk full\n", 3)
+			if err := f.Run(strings.NewReader(input), "app.log"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package logfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extractor adds fields taken from a record's message to Record.Fields.
+type Extractor interface {
+	Extract(rec *Record)
+}
+
+// RegexExtractor extracts the named capture groups of a regular expression,
+// e.g. `user with ID '(?P<user_id>\d+)'` turns "Failed to find user with
+// ID '42'" into the field user_id=42. Unnamed groups are ignored.
+type RegexExtractor struct {
+	re *regexp.Regexp
+}
+
+// NewRegexExtractor compiles pattern, which must have at least one named
+// capture group.
+func NewRegexExtractor(pattern string) (*RegexExtractor, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	named := false
+	for _, name := range re.SubexpNames() {
+		named = named || name != ""
+	}
+	if !named {
+		return nil, fmt.Errorf("pattern %q has no named capture group such as (?P<name>...)", pattern)
+	}
+	return &RegexExtractor{re: re}, nil
+}
+
+// String returns the pattern.
+func (e *RegexExtractor) String() string { return e.re.String() }
+
+// Extract implements Extractor. Groups that did not take part in the match
+// are not set.
+func (e *RegexExtractor) Extract(rec *Record) {
+	m := e.re.FindStringSubmatchIndex(rec.Message)
+	if m == nil {
+		return
+	}
+	for i, name := range e.re.SubexpNames() {
+		if name == "" || m[2*i] < 0 {
+			continue
+		}
+		rec.SetField(name, rec.Message[m[2*i]:m[2*i+1]])
+	}
+}
+
+// kvPattern matches key=value and key="quoted value" pairs inside a message.
+var kvPattern = regexp.MustCompile(`(?:^|\s)([A-Za-z_][\w.-]*)=("(?:[^"\\]|\\.)*"|[^\s"]*)`)
+
+// KeyValueExtractor extracts key=value pairs found anywhere in the message,
+// such as "request done status=200 path=/api".
+type KeyValueExtractor struct{}
+
+// Extract implements Extractor.
+func (KeyValueExtractor) Extract(rec *Record) {
+	for _, m := range kvPattern.FindAllStringSubmatch(rec.Message, -1) {
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			if v, err := strconv.Unquote(value); err == nil {
+				value = v
+			}
+		}
+		rec.SetField(m[1], value)
+	}
+}
+
+// SetField sets an extracted field, allocating the map on first use.
+func (r *Record) SetField(name, value string) {
+	if r.Fields == nil {
+		r.Fields = map[string]string{}
+	}
+	r.Fields[name] = value
+}
+
+// Field returns the value of a named field: one of the built-in fields
+// (time or ts, level, message or msg, source or file, raw or line) or an
+// extracted one. ok is false when the record has no such field.
+func (r Record) Field(name string) (value string, ok bool) {
+	switch strings.ToLower(name) {
+	case "time", "ts":
+		if r.Time.IsZero() {
+			return "", true
+		}
+		return r.Time.Format(time.RFC3339Nano), true
+	case "level":
+		return r.Level, true
+	case "message", "msg":
+		return r.Message, true
+	case "source", "file":
+		return r.Source, true
+	case "raw", "line":
+		return r.Raw, true
+	}
+	value, ok = r.Fields[name]
+	return value, ok
+}
+
+// ParseFieldList splits a comma-separated list of field names such as
+// "ts,level,user_id", dropping blanks.
+func ParseFieldList(list string) []string {
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
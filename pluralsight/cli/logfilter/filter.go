@@ -0,0 +1,463 @@
+// Package logfilter parses, filters and summarises log files of the form
+// "YYYY-MM-DD HH:MM:SS LEVEL message".
+//
+// A Filter reads lines from an io.Reader (or a file, which may be
+// compressed, split across workers or followed like `tail -F`), parses
+// them into Records, keeps the ones accepted by all of its Matchers and
+// writes them to a Sink:
+//
+//	sink, _ := logfilter.NewSink("ndjson", os.Stdout, logfilter.SinkOptions{})
+//	f := &logfilter.Filter{
+//		Matchers: []logfilter.Matcher{logfilter.LevelRange{Min: logfilter.SeverityWarning, Max: logfilter.SeverityEmergency}},
+//		Sink:     sink,
+//	}
+//	err := f.Run(strings.NewReader(logs), "inline")
+//	sink.Close()
+package logfilter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Matcher decides whether a parsed record is kept. LevelIs, LevelRange,
+// TimeRange and Query all implement it.
+type Matcher interface {
+	Match(rec Record) bool
+}
+
+// MatcherFunc adapts an ordinary function to the Matcher interface.
+type MatcherFunc func(rec Record) bool
+
+// Match calls f(rec).
+func (f MatcherFunc) Match(rec Record) bool { return f(rec) }
+
+// Sink receives the records kept by a Filter. The writers returned by
+// NewSink, Stats, Clusterer and Alerter all implement it.
+type Sink interface {
+	// Write consumes one record.
+	Write(rec Record) error
+	// Close finishes the output, e.g. closes a JSON array, and flushes it.
+	Close() error
+}
+
+// Filter parses lines, keeps the records accepted by every Matcher and
+// writes them to Sink. A Filter may be shared by several goroutines (as
+// when following many files at once); calls into the Sink are serialised.
+type Filter struct {
+	// Parser turns lines into records. The zero value parses log.txt.
+	// Its layout and zone also configure detected formats.
+	Parser Parser
+
+	// Format, if set, replaces Parser for parsing lines (see FormatSpec).
+	Format Format
+
+	// AutoDetect picks the format of each input from its first lines with
+	// DetectFormat. It is ignored when Format is set.
+	AutoDetect bool
+
+	// Extractors add fields to each parsed record before it is matched,
+	// so queries can refer to them.
+	Extractors []Extractor
+
+	// Matchers must all accept a record for it to be written. An empty
+	// list keeps every record.
+	Matchers []Matcher
+
+	// Sink receives the kept records. It must not be nil.
+	Sink Sink
+
+	// OnInvalid, if set, is called for every line that cannot be parsed.
+	// Such lines never match.
+	OnInvalid func(rec Record, err error)
+
+	// Workers is the number of goroutines RunFile uses to scan large
+	// files. Values below 2 disable parallel scanning. It is capped at
+	// GOMAXPROCS, since extra workers only add overhead (see
+	// BenchmarkScanParallel).
+	Workers int
+
+	// Before and After are the numbers of context records written before
+	// and after each match, like grep -B and -A. Context records have
+	// Record.Context set. Context requires reading lines in order, so it
+	// disables parallel scanning.
+	Before, After int
+
+	// Multiline groups continuation lines (stack traces and the like) into
+	// the preceding record, see LineGrouper. RecordStart, if set, is the
+	// pattern of a line that starts a new record; by default any line the
+	// Parser accepts that is not indented does. Multiline disables parallel
+	// scanning, since chunk boundaries could split a record.
+	Multiline   bool
+	RecordStart *regexp.Regexp
+
+	// IgnoreIndex makes RunFile scan files in full even when a fresh
+	// sidecar index (see BuildIndex) could narrow down the lines to read.
+	IgnoreIndex bool
+
+	mu      sync.Mutex
+	invalid int
+	matched int
+
+	// Context bookkeeping, see recordWithContext.
+	contexts   map[string]*contextState
+	printedAny bool
+	lastSource string
+}
+
+// Invalid returns the number of lines that could not be parsed so far.
+func (f *Filter) Invalid() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.invalid
+}
+
+// Matched returns the number of records written to the Sink so far, not
+// counting context records.
+func (f *Filter) Matched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.matched
+}
+
+// Match reports whether rec is accepted by every Matcher.
+func (f *Filter) Match(rec Record) bool {
+	for _, m := range f.Matchers {
+		if !m.Match(rec) {
+			return false
+		}
+	}
+	return true
+}
+
+// lineResult is a parsed line, or one that failed to parse (err != nil).
+type lineResult struct {
+	rec Record
+	err error
+}
+
+// DetectSampleSize is the number of leading lines of an input used to
+// detect its format when Filter.AutoDetect is set.
+const DetectSampleSize = 10
+
+// format returns the explicitly configured format, or nil when it has to be
+// detected per input.
+func (f *Filter) format() Format {
+	switch {
+	case f.Format != nil:
+		return f.Format
+	case f.AutoDetect:
+		return nil
+	}
+	return f.Parser
+}
+
+// evaluate parses one line (or multi-line record) and applies the matchers.
+// keep is true for matching and for unparsable lines. It touches no mutable
+// state, so the parallel scanner calls it from several goroutines at once.
+func (f *Filter) evaluate(format Format, source, line string) (res lineResult, keep bool) {
+	rec, err := ParseRecord(format, line)
+	rec.Source = source
+	if err != nil {
+		return lineResult{rec: rec, err: err}, true
+	}
+	for _, x := range f.Extractors {
+		x.Extract(&rec)
+	}
+	return lineResult{rec: rec}, f.Match(rec)
+}
+
+// hasContext reports whether context records are requested.
+func (f *Filter) hasContext() bool {
+	return f.Before > 0 || f.After > 0
+}
+
+// record consumes an evaluated line: invalid lines are counted and
+// reported, matches are written to the sink. The caller holds f.mu.
+func (f *Filter) record(res lineResult, flush bool) error {
+	if res.err != nil {
+		f.invalid++
+		if f.OnInvalid != nil {
+			f.OnInvalid(res.rec, res.err)
+		}
+		return nil
+	}
+	if err := f.Sink.Write(res.rec); err != nil {
+		return err
+	}
+	if !res.rec.Context {
+		f.matched++
+	}
+	if fl, ok := f.Sink.(Flusher); ok && flush {
+		return fl.Flush()
+	}
+	return nil
+}
+
+// Process parses and filters a single line read from source. With
+// AutoDetect and no Format, the line is parsed with Parser.
+func (f *Filter) Process(source, line string) error {
+	format := f.format()
+	if format == nil {
+		format = f.Parser
+	}
+	return f.process(format, source, line, false)
+}
+
+func (f *Filter) process(format Format, source, line string, flush bool) error {
+	res, keep := f.evaluate(format, source, line)
+	if !keep && !f.hasContext() {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hasContext() {
+		return f.recordWithContext(res, keep, flush)
+	}
+	return f.record(res, flush)
+}
+
+// Run filters every line read from r. source names the input in records
+// and error messages.
+func (f *Filter) Run(r io.Reader, source string) error {
+	return f.scan(source, false, DetectSampleSize, func(fn func(string) error) error { return ScanLines(r, fn) })
+}
+
+// scan feeds the lines produced by read to process. When the format has to
+// be detected, the first sample lines are held back until it is known.
+// With Multiline set, lines are grouped into records before processing.
+func (f *Filter) scan(source string, flush bool, sample int, read func(fn func(line string) error) error) error {
+	// next receives the raw lines once the format is known; finish completes
+	// the last multi-line record.
+	var next, finish func(...string) error
+	start := func(format Format) {
+		emit := func(text string) error { return f.process(format, source, text, flush) }
+		var add func(string) error = emit
+		finish = func(...string) error { return nil }
+		if f.Multiline {
+			g := &LineGrouper{IsStart: RecordStartFunc(format, f.RecordStart), Emit: emit}
+			add = g.Add
+			finish = func(...string) error { return g.Flush() }
+		}
+		next = func(lines ...string) error {
+			for _, l := range lines {
+				if err := add(l); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var held []string
+	detect := func() error {
+		_, format := DetectFormat(held, f.Parser)
+		start(format)
+		lines := held
+		held = nil
+		return next(lines...)
+	}
+	if format := f.format(); format != nil {
+		start(format)
+	}
+
+	err := read(func(line string) error {
+		if next != nil {
+			return next(line)
+		}
+		held = append(held, line)
+		if len(held) < sample {
+			return nil
+		}
+		return detect()
+	})
+	// Inputs shorter than the sample are detected from what there is.
+	if err == nil && next == nil {
+		err = detect()
+	}
+	if err != nil {
+		return err
+	}
+	return finish()
+}
+
+// RunFile filters the named input: a file path, or StdinName for standard
+// input. Compressed files are decompressed, files with a fresh sidecar index
+// only have their candidate lines read, and large regular files are scanned
+// in parallel when Workers is above 1.
+func (f *Filter) RunFile(name string) error {
+	source := DisplayName(name)
+	if !f.IgnoreIndex && !f.hasContext() && !f.Multiline {
+		done, err := f.runIndexed(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		if done {
+			return nil
+		}
+	}
+	if f.workers() > 1 && !f.hasContext() && !f.Multiline {
+		done, err := f.runParallel(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		if done {
+			return nil
+		}
+	}
+
+	file, err := OpenInput(name)
+	if err != nil {
+		return err
+	}
+	// The file is closed as soon as it has been read, so a long list of
+	// inputs does not keep every file open until the caller returns.
+	defer file.Close()
+
+	if err := f.Run(file, source); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+// workers returns the number of goroutines used for parallel scanning.
+func (f *Filter) workers() int {
+	return min(f.Workers, runtime.GOMAXPROCS(0))
+}
+
+// runParallel scans a large, uncompressed regular file with ScanParallel.
+// It reports false without reading anything when the input is not
+// suitable, in which case the caller falls back to the sequential path.
+func (f *Filter) runParallel(name string) (bool, error) {
+	if name == StdinName {
+		return false, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() || info.Size() <= DefaultChunkSize {
+		return false, nil
+	}
+	// Compressed streams cannot be split at arbitrary offsets.
+	head := make([]byte, 4)
+	n, _ := file.ReadAt(head, 0)
+	if IsCompressed(head[:n]) {
+		return false, nil
+	}
+
+	format := f.format()
+	if format == nil {
+		_, format = detectAt(file, f.Parser)
+	}
+	eval := func(line string) (lineResult, bool) { return f.evaluate(format, name, line) }
+	emit := func(res lineResult) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.record(res, false)
+	}
+	return true, ScanParallel(file, info.Size(), f.workers(), DefaultChunkSize, eval, emit)
+}
+
+// runIndexed reads only the lines the sidecar index of name selects for the
+// matchers. Like runParallel it reports false, having read nothing, when
+// the index is missing, stale (the file changed since it was built), was
+// built with other parsing settings, or would not save much work.
+func (f *Filter) runIndexed(name string) (bool, error) {
+	if name == StdinName {
+		return false, nil
+	}
+	idx, err := ReadIndex(name)
+	if err != nil {
+		return false, nil
+	}
+	if idx.Layout != f.Parser.layout() || idx.Location != f.Parser.location().String() {
+		return false, nil
+	}
+	spec, ok := LookupFormat(idx.Format)
+	if !ok {
+		return false, nil
+	}
+	format := spec.New(f.Parser)
+	switch {
+	case f.Format != nil && !reflect.DeepEqual(f.Format, format):
+		return false, nil
+	case f.Format == nil && !f.AutoDetect && idx.Format != "plain":
+		return false, nil
+	}
+	lines, ok := idx.candidates(f.Matchers)
+	// Reading most lines one by one is slower than a sequential scan.
+	if !ok || len(lines) > idx.Lines()/2 {
+		return false, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !idx.Fresh(info) {
+		return false, nil
+	}
+
+	source := DisplayName(name)
+	var buf []byte
+	for _, n := range lines {
+		start, end := idx.Offsets[n], idx.Size
+		if int(n)+1 < len(idx.Offsets) {
+			end = idx.Offsets[n+1]
+		}
+		buf = slices.Grow(buf[:0], int(end-start))[:end-start]
+		if _, err := file.ReadAt(buf, start); err != nil && err != io.EOF {
+			return true, err
+		}
+		if err := f.process(format, source, strings.TrimRight(string(buf), "\r\n"), false); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Follow filters the file at path like `tail -F` until ctx is cancelled
+// (see FollowLines). The sink is flushed after every record so matches
+// appear as soon as they are written. Standard input cannot be reopened,
+// so StdinName is simply read until it is closed.
+//
+// With Multiline set, a record is only complete once the next one starts,
+// so the last record of a followed file is written when ctx is cancelled.
+func (f *Filter) Follow(ctx context.Context, path string, poll time.Duration) error {
+	if path == StdinName {
+		file, err := OpenInput(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return f.scan(DisplayName(path), true, DetectSampleSize, func(fn func(string) error) error {
+			return ScanLines(file, fn)
+		})
+	}
+	// A followed file may grow slowly, so its format is detected from the
+	// first line instead of waiting for a full sample.
+	return f.scan(path, true, 1, func(fn func(string) error) error {
+		return FollowLines(ctx, path, poll, fn)
+	})
+}
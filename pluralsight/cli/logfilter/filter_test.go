@@ -0,0 +1,246 @@
+package logfilter
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLogTxt returns the sample log.txt shipped with the CLI.
+func readLogTxt(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("../log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// mustQuery compiles a query against the default Parser.
+func mustQuery(t *testing.T, src string) Query {
+	t.Helper()
+	q, err := ParseQuery(src, Parser{}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestFilterRunLogTxt(t *testing.T) {
+	logTxt := readLogTxt(t)
+	epoch := time.Unix(0, 0).UTC()
+	const (
+		info      = "This is an informational message"
+		emergency = "Failed to connect to database"
+		debug     = "This is an debug message"
+		grpc      = "gRPC client failed to connect to server"
+		user      = "Failed to find user with ID '42'"
+	)
+	// garbage follows log.txt in some cases, so Invalid has something to
+	// count; log.txt ends without a newline.
+	const garbage = "\nnot a log line\n1970-01-01 00:00:00\n\n"
+
+	tests := []struct {
+		name        string
+		matchers    func(t *testing.T) []Matcher
+		garbage     bool
+		want        []string // messages, in order
+		wantInvalid int
+	}{
+		{
+			name:     "no matchers",
+			matchers: func(*testing.T) []Matcher { return nil },
+			want:     []string{info, emergency, debug, grpc, user, info, info, info},
+		},
+		{
+			name:     "LevelIs CRITICAL",
+			matchers: func(*testing.T) []Matcher { return []Matcher{LevelIs("CRITICAL")} },
+			want:     []string{grpc, user},
+		},
+		{
+			name:     "LevelIs is case-sensitive",
+			matchers: func(*testing.T) []Matcher { return []Matcher{LevelIs("critical")} },
+		},
+		{
+			name:        "LevelIs INFO with invalid lines",
+			matchers:    func(*testing.T) []Matcher { return []Matcher{LevelIs("INFO")} },
+			garbage:     true,
+			want:        []string{info, info, info, info},
+			wantInvalid: 3,
+		},
+		{
+			name: "LevelRange WARNING and above",
+			matchers: func(*testing.T) []Matcher {
+				return []Matcher{LevelRange{Min: SeverityWarning, Max: SeverityEmergency}}
+			},
+			want: []string{emergency, grpc, user},
+		},
+		{
+			name: "LevelRange DEBUG to INFO",
+			matchers: func(*testing.T) []Matcher {
+				return []Matcher{LevelRange{Min: SeverityDebug, Max: SeverityInfo}}
+			},
+			garbage:     true,
+			want:        []string{info, debug, info, info, info},
+			wantInvalid: 3,
+		},
+		{
+			name:     "TimeRange since the epoch",
+			matchers: func(*testing.T) []Matcher { return []Matcher{TimeRange{Since: epoch}} },
+			want:     []string{info, emergency, debug, grpc, user, info, info, info},
+		},
+		{
+			name:     "TimeRange until the epoch is exclusive",
+			matchers: func(*testing.T) []Matcher { return []Matcher{TimeRange{Until: epoch}} },
+		},
+		{
+			name: "TimeRange and LevelIs",
+			matchers: func(*testing.T) []Matcher {
+				return []Matcher{TimeRange{Since: epoch, Until: epoch.Add(time.Second)}, LevelIs("EMERGENCY")}
+			},
+			want: []string{emergency},
+		},
+		{
+			name: "Query level ordering and regex",
+			matchers: func(t *testing.T) []Matcher {
+				return []Matcher{mustQuery(t, "level >= ERROR AND message =~ /connect/")}
+			},
+			want: []string{emergency, grpc},
+		},
+		{
+			name: "Query NOT contains",
+			matchers: func(t *testing.T) []Matcher {
+				return []Matcher{mustQuery(t, `level = CRITICAL AND NOT message contains "gRPC"`)}
+			},
+			want: []string{user},
+		},
+		{
+			name: "Query has words in any case",
+			matchers: func(t *testing.T) []Matcher {
+				return []Matcher{mustQuery(t, "message has 'DATABASE failed' OR level = debug")}
+			},
+			garbage:     true,
+			want:        []string{emergency, debug},
+			wantInvalid: 3,
+		},
+		{
+			name: "Query OR with a case-insensitive level",
+			matchers: func(t *testing.T) []Matcher {
+				return []Matcher{mustQuery(t, "message contains 'database' OR level = debug")}
+			},
+			garbage:     true,
+			want:        []string{emergency, debug},
+			wantInvalid: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := logTxt
+			if tt.garbage {
+				input += garbage
+			}
+			sink := &recordSink{}
+			f := &Filter{Matchers: tt.matchers(t), Sink: sink}
+			if err := f.Run(strings.NewReader(input), "log.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, rec := range sink.records {
+				got = append(got, rec.Message)
+				if rec.Source != "log.txt" {
+					t.Errorf("record %q has source %q, want log.txt", rec.Raw, rec.Source)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if f.Matched() != len(tt.want) {
+				t.Errorf("Matched() = %d, want %d", f.Matched(), len(tt.want))
+			}
+			if f.Invalid() != tt.wantInvalid {
+				t.Errorf("Invalid() = %d, want %d", f.Invalid(), tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Record
+		wantErr bool
+	}{
+		{
+			line: "1970-01-01 00:00:00 CRITICAL Failed to find user with ID '42'",
+			want: Record{Time: time.Unix(0, 0).UTC(), Level: "CRITICAL", Message: "Failed to find user with ID '42'"},
+		},
+		{
+			line: "2024-05-01 02:03:04 INFO  two  spaces kept\r\n",
+			want: Record{Time: time.Date(2024, 5, 1, 2, 3, 4, 0, time.UTC), Level: "INFO", Message: " two  spaces kept"},
+		},
+		{
+			line: "2024-05-01 02:03:04 WARNING",
+			want: Record{Time: time.Date(2024, 5, 1, 2, 3, 4, 0, time.UTC), Level: "WARNING"},
+		},
+		{line: "", wantErr: true},
+		{line: "not a log line", wantErr: true},
+		{line: "1970-01-01 00:00:00", wantErr: true},
+		{line: "1970-13-01 00:00:00 INFO bad month", wantErr: true},
+		{line: "1970-01-01 00:00:00 info lower-case level", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if tt.wantErr {
+				if !errors.Is(err, ErrMalformed) {
+					t.Fatalf("ParseLine(%q) error = %v, want ErrMalformed", tt.line, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLine(%q): %v", tt.line, err)
+			}
+			if !got.Time.Equal(tt.want.Time) || got.Level != tt.want.Level || got.Message != tt.want.Message {
+				t.Errorf("ParseLine(%q) = %v %q %q, want %v %q %q", tt.line, got.Time, got.Level, got.Message, tt.want.Time, tt.want.Level, tt.want.Message)
+			}
+			if want := strings.TrimRight(tt.line, "\r\n"); got.Raw != want {
+				t.Errorf("Raw = %q, want %q", got.Raw, want)
+			}
+		})
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Severity
+		wantErr bool
+	}{
+		{"DEBUG", SeverityDebug, false},
+		{"info", SeverityInfo, false},
+		{" Notice ", SeverityNotice, false},
+		{"WARNING", SeverityWarning, false},
+		{"warn", SeverityWarning, false},
+		{"ERR", SeverityError, false},
+		{"CRITICAL", SeverityCritical, false},
+		{"crit", SeverityCritical, false},
+		{"ALERT", SeverityAlert, false},
+		{"EMERGENCY", SeverityEmergency, false},
+		{"emerg", SeverityEmergency, false},
+		{"", SeverityUnknown, true},
+		{"FATAL", SeverityUnknown, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSeverity(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSeverity(%q) = %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if SeverityWarning.String() != "WARNING" || SeverityUnknown.String() != "UNKNOWN" {
+		t.Errorf("String() = %q, %q", SeverityWarning, SeverityUnknown)
+	}
+}
@@ -0,0 +1,135 @@
+package logfilter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultPollInterval is how often a followed file is checked for new data
+// when the previous read reached end of file.
+const DefaultPollInterval = 250 * time.Millisecond
+
+// FollowLines reads the file at path like `tail -F`: it passes every existing
+// line to fn, then keeps waiting for appended lines until ctx is cancelled or
+// fn returns an error.
+//
+// The path is re-checked on every poll, so the follower copes with both kinds
+// of log rotation:
+//   - truncation (copytruncate): the file shrinks below the read offset and
+//     is read again from the start;
+//   - rename and recreate: a different file (new inode) appears at the path;
+//     the old file is drained and the new one is opened from the start.
+//
+// A line is only passed to fn once its terminating newline has been written,
+// so a writer flushing half a line is never reported as two records.
+func FollowLines(ctx context.Context, path string, poll time.Duration, fn func(line string) error) error {
+	if poll <= 0 {
+		poll = DefaultPollInterval
+	}
+
+	file, info, err := openWithInfo(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var offset int64   // bytes consumed from the current file
+	var partial string // an unterminated line waiting for the rest of its text
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	// drain reads all complete lines currently available in the open file.
+	drain := func() error {
+		for {
+			chunk, err := reader.ReadString('\n')
+			offset += int64(len(chunk))
+			partial += chunk
+			if strings.HasSuffix(partial, "\n") {
+				line := strings.TrimRight(partial, "\r\n")
+				partial = ""
+				if ferr := fn(line); ferr != nil {
+					return ferr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		if err := drain(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// Between rename and recreate the path briefly does not exist.
+			continue
+		case err != nil:
+			return err
+		case !os.SameFile(info, current):
+			// Rotated: finish the old file, then switch to the new one.
+			if err := drain(); err != nil {
+				return err
+			}
+			if partial != "" {
+				line := strings.TrimRight(partial, "\r\n")
+				partial = ""
+				if err := fn(line); err != nil {
+					return err
+				}
+			}
+			newFile, newInfo, err := openWithInfo(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			file.Close()
+			file, info = newFile, newInfo
+			reader.Reset(file)
+			offset = 0
+		case current.Size() < offset:
+			// Truncated in place: start over from the beginning.
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			offset, partial = 0, ""
+		}
+	}
+}
+
+// openWithInfo opens path and returns its FileInfo, which identifies the
+// underlying file for os.SameFile even after the path has been reused.
+func openWithInfo(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
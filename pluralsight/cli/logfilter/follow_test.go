@@ -0,0 +1,132 @@
+package logfilter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFollowLines(t *testing.T) {
+	// Each step changes the followed file and lists the lines FollowLines
+	// must report afterwards.
+	type step struct {
+		name   string
+		change func(t *testing.T, path string)
+		want   []string
+	}
+	appendText := func(text string) func(*testing.T, string) {
+		return func(t *testing.T, path string) {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString(text); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFile := func(text string) func(*testing.T, string) {
+		return func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name    string
+		initial string
+		steps   []step
+	}{
+		{
+			name:    "appended lines",
+			initial: "one\ntwo\n",
+			steps: []step{
+				{"existing lines", func(*testing.T, string) {}, []string{"one", "two"}},
+				{"append", appendText("three\r\n"), []string{"three"}},
+			},
+		},
+		{
+			name:    "partial lines wait for their newline",
+			initial: "one\n",
+			steps: []step{
+				{"existing lines", func(*testing.T, string) {}, []string{"one"}},
+				{"first half", appendText("tw"), nil},
+				{"second half", appendText("o\n"), []string{"two"}},
+			},
+		},
+		{
+			name:    "truncation",
+			initial: "one\ntwo\nthree\n",
+			steps: []step{
+				{"existing lines", func(*testing.T, string) {}, []string{"one", "two", "three"}},
+				{"copytruncate", writeFile("four\n"), []string{"four"}},
+				{"append", appendText("five\n"), []string{"five"}},
+			},
+		},
+		{
+			name:    "rename and recreate",
+			initial: "one\n",
+			steps: []step{
+				{"existing lines", func(*testing.T, string) {}, []string{"one"}},
+				{"rotate", func(t *testing.T, path string) {
+					// The old file gets a last, unterminated line before it is
+					// rotated away; it is reported when the follower switches.
+					appendText("two\nlast")(t, path)
+					if err := os.Rename(path, path+".1"); err != nil {
+						t.Fatal(err)
+					}
+					writeFile("three\n")(t, path)
+				}, []string{"two", "last", "three"}},
+				{"append", appendText("four\n"), []string{"four"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			writeFile(tt.initial)(t, path)
+
+			lines := make(chan string, 100)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- FollowLines(ctx, path, 5*time.Millisecond, func(line string) error {
+					lines <- line
+					return nil
+				})
+			}()
+
+			for _, s := range tt.steps {
+				s.change(t, path)
+				var got []string
+				timeout := time.After(2 * time.Second)
+				for len(got) < len(s.want) {
+					select {
+					case line := <-lines:
+						got = append(got, line)
+					case <-timeout:
+						t.Fatalf("%s: got %q before the timeout, want %q", s.name, got, s.want)
+					}
+				}
+				// Nothing else may arrive, e.g. half a line or a line read twice.
+				select {
+				case line := <-lines:
+					got = append(got, line)
+				case <-time.After(50 * time.Millisecond):
+				}
+				if !slices.Equal(got, s.want) {
+					t.Errorf("%s: lines = %q, want %q", s.name, got, s.want)
+				}
+			}
+
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("FollowLines: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package logfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format parses one kind of log line into a Record. Parser, the layout of
+// log.txt, is the "plain" format; the others are registered in this file.
+type Format interface {
+	Parse(line string) (Record, error)
+}
+
+// FormatSpec describes a registered input format.
+type FormatSpec struct {
+	// Name selects the format, e.g. on the CLI's -format flag.
+	Name string
+
+	// New returns the format configured with the time layout and zone of
+	// p. Formats whose timestamps carry their own layout only use
+	// p.Location, for timestamps without an offset.
+	New func(p Parser) Format
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []FormatSpec
+)
+
+// RegisterFormat adds a format to the registry, or replaces the one with the
+// same name. During auto-detection earlier registrations win ties, so more
+// specific formats should be registered first.
+func RegisterFormat(spec FormatSpec) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for i, f := range formats {
+		if f.Name == spec.Name {
+			formats[i] = spec
+			return
+		}
+	}
+	formats = append(formats, spec)
+}
+
+// LookupFormat returns the registered format with the given name.
+func LookupFormat(name string) (FormatSpec, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return FormatSpec{}, false
+}
+
+// FormatNames lists the registered formats in detection order.
+func FormatNames() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// DetectFormat picks the registered format that parses the most lines of
+// sample, configured from p. Blank lines are ignored. When no format
+// parses any line, the plain format (p itself) is returned.
+func DetectFormat(sample []string, p Parser) (name string, format Format) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	name, format, best := "plain", p, 0
+	for _, spec := range formats {
+		candidate := spec.New(p)
+		score := 0
+		for _, line := range sample {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if _, err := candidate.Parse(line); err == nil {
+				score++
+			}
+		}
+		if score > best {
+			name, format, best = spec.Name, candidate, score
+		}
+	}
+	return name, format
+}
+
+// ParseRecord parses text with f. text may hold a multi-line record (see
+// LineGrouper): only its first line is given to f, and the continuation
+// lines are appended to the message, separated by newlines.
+func ParseRecord(f Format, text string) (Record, error) {
+	first, rest, multi := strings.Cut(text, "\n")
+	rec, err := f.Parse(first)
+	if multi {
+		rec.Raw = strings.TrimRight(text, "\r\n")
+		rec.Message += "\n" + rest
+	}
+	return rec, err
+}
+
+func init() {
+	// Registration order is the tie-break order for detection: the
+	// structured formats are unambiguous, plain must beat logfmt because
+	// logfmt accepts bare words.
+	RegisterFormat(FormatSpec{Name: "json", New: func(p Parser) Format { return jsonFormat{p} }})
+	RegisterFormat(FormatSpec{Name: "syslog", New: func(p Parser) Format { return syslogFormat{} }})
+	RegisterFormat(FormatSpec{Name: "access", New: func(p Parser) Format { return accessFormat{} }})
+	RegisterFormat(FormatSpec{Name: "plain", New: func(p Parser) Format { return p }})
+	RegisterFormat(FormatSpec{Name: "logfmt", New: func(p Parser) Format { return logfmtFormat{p} }})
+}
+
+// numericLevels are the numeric levels written by bunyan and pino.
+var numericLevels = map[string]Severity{
+	"10": SeverityDebug,
+	"20": SeverityDebug,
+	"30": SeverityInfo,
+	"40": SeverityWarning,
+	"50": SeverityError,
+	"60": SeverityCritical,
+}
+
+// canonicalLevel maps level spellings used by other loggers ("warn",
+// "Error", "fatal", 50) onto the names of the severity hierarchy, so -level
+// and -min-level work the same for every format. Every Format sets
+// Record.Level through it or from a Severity.
+func canonicalLevel(level string) string {
+	if s, ok := numericLevels[level]; ok {
+		return s.String()
+	}
+	if strings.EqualFold(level, "fatal") || strings.EqualFold(level, "panic") {
+		return SeverityCritical.String()
+	}
+	if strings.EqualFold(level, "trace") {
+		return SeverityDebug.String()
+	}
+	if s, err := ParseSeverity(level); err == nil {
+		return s.String()
+	}
+	return strings.ToUpper(level)
+}
+
+// parseFlexibleTime parses timestamps as written by structured loggers:
+// RFC 3339, the configured layout, or Unix seconds/milliseconds.
+func parseFlexibleTime(value string, p Parser) (time.Time, error) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	layouts := []string{time.RFC3339Nano, p.Layout, DefaultTimeLayout, "2006-01-02 15:04:05.999999999"}
+	for _, l := range layouts {
+		if l == "" {
+			continue
+		}
+		if t, err := time.ParseInLocation(l, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		// Values this large can only be milliseconds since the epoch.
+		if f > 1e11 {
+			return time.UnixMilli(int64(f)).In(loc), nil
+		}
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).In(loc), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", value)
+}
+
+// Key names commonly used for the standard fields by structured loggers.
+var (
+	timeKeys    = []string{"time", "ts", "timestamp", "@timestamp", "t"}
+	levelKeys   = []string{"level", "lvl", "severity", "levelname", "loglevel"}
+	messageKeys = []string{"msg", "message", "@message"}
+)
+
+// fillFromMap sets the time, level and message of rec from a decoded
+// structured line. At least a level or a message must be present.
+func fillFromMap(rec *Record, get func(key string) (string, bool), p Parser) error {
+	found := false
+	for _, k := range timeKeys {
+		if v, ok := get(k); ok {
+			t, err := parseFlexibleTime(v, p)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrMalformed, err)
+			}
+			rec.Time = t
+			break
+		}
+	}
+	for _, k := range levelKeys {
+		if v, ok := get(k); ok {
+			rec.Level = canonicalLevel(v)
+			found = true
+			break
+		}
+	}
+	for _, k := range messageKeys {
+		if v, ok := get(k); ok {
+			rec.Message = v
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: no level or message field", ErrMalformed)
+	}
+	return nil
+}
+
+// isStandardKey reports whether key is one of the names fillFromMap reads.
+func isStandardKey(key string) bool {
+	return slices.Contains(timeKeys, key) || slices.Contains(levelKeys, key) || slices.Contains(messageKeys, key)
+}
+
+// jsonFormat parses JSON lines such as
+// {"time":"2024-05-01T02:00:00Z","level":"error","msg":"connect failed"}.
+type jsonFormat struct{ p Parser }
+
+func (f jsonFormat) Parse(line string) (Record, error) {
+	raw := strings.TrimRight(line, "\r\n")
+	rec := Record{Raw: raw}
+	if !strings.HasPrefix(strings.TrimSpace(raw), "{") {
+		return rec, fmt.Errorf("%w: not a JSON object", ErrMalformed)
+	}
+	// UseNumber keeps numbers as written: decoded into float64, 12345678
+	// would come back as 1.2345678e+07 and no longer equal "12345678".
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return rec, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return rec, fmt.Errorf("%w: data after the JSON object", ErrMalformed)
+	}
+	get := func(key string) (string, bool) {
+		v, ok := obj[key]
+		if !ok || v == nil {
+			return "", false
+		}
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case json.Number:
+			return v.String(), true
+		case map[string]any, []any:
+			// Nested values are kept as compact JSON.
+			b, _ := json.Marshal(v)
+			return string(b), true
+		}
+		return fmt.Sprint(v), true
+	}
+	if err := fillFromMap(&rec, get, f.p); err != nil {
+		return rec, err
+	}
+	// Every other key becomes a field, e.g. for -fields or a query.
+	for k := range obj {
+		if v, ok := get(k); ok && !isStandardKey(k) {
+			rec.SetField(k, v)
+		}
+	}
+	return rec, nil
+}
+
+// logfmtFormat parses logfmt lines such as
+// time=2024-05-01T02:00:00Z level=error msg="connect failed" attempt=3.
+type logfmtFormat struct{ p Parser }
+
+func (f logfmtFormat) Parse(line string) (Record, error) {
+	raw := strings.TrimRight(line, "\r\n")
+	rec := Record{Raw: raw}
+	pairs, err := ParseLogfmt(raw)
+	if err != nil {
+		return rec, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	get := func(key string) (string, bool) {
+		v, ok := pairs[key]
+		return v, ok
+	}
+	if err := fillFromMap(&rec, get, f.p); err != nil {
+		return rec, err
+	}
+	for k, v := range pairs {
+		if !isStandardKey(k) {
+			rec.SetField(k, v)
+		}
+	}
+	return rec, nil
+}
+
+// ParseLogfmt splits a logfmt line into its key=value pairs. Values may be
+// double-quoted with Go escapes; a bare key has an empty value.
+func ParseLogfmt(line string) (map[string]string, error) {
+	pairs := map[string]string{}
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i == len(line) {
+			break
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" || strings.ContainsAny(key, `"`) {
+			return nil, fmt.Errorf("invalid key at offset %d", start)
+		}
+		if i == len(line) || line[i] == ' ' {
+			pairs[key] = ""
+			continue
+		}
+		i++ // skip '='
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted value for %q", key)
+			}
+			v, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted value for %q: %v", key, err)
+			}
+			pairs[key] = v
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+		pairs[key] = line[start:i]
+	}
+	return pairs, nil
+}
+
+// syslogPattern matches RFC 5424 messages:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+var syslogPattern = regexp.MustCompile(`^<(\d{1,3})>\d{1,2} (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: (.*))?$`)
+
+// syslogSeverities maps the RFC 5424 severity (PRI mod 8) onto level names.
+var syslogSeverities = [8]Severity{
+	SeverityEmergency, SeverityAlert, SeverityCritical, SeverityError,
+	SeverityWarning, SeverityNotice, SeverityInfo, SeverityDebug,
+}
+
+// syslogFormat parses RFC 5424 syslog lines. The level comes from the
+// severity encoded in the priority value; the hostname and app name are
+// kept as the fields "hostname" and "app".
+type syslogFormat struct{}
+
+func (syslogFormat) Parse(line string) (Record, error) {
+	raw := strings.TrimRight(line, "\r\n")
+	rec := Record{Raw: raw}
+	m := syslogPattern.FindStringSubmatch(raw)
+	if m == nil {
+		return rec, fmt.Errorf("%w: not an RFC 5424 syslog line", ErrMalformed)
+	}
+	pri, err := strconv.Atoi(m[1])
+	if err != nil || pri > 191 {
+		return rec, fmt.Errorf("%w: bad priority %q", ErrMalformed, m[1])
+	}
+	if m[2] != "-" {
+		if rec.Time, err = time.Parse(time.RFC3339Nano, m[2]); err != nil {
+			return rec, fmt.Errorf("%w: bad timestamp: %v", ErrMalformed, err)
+		}
+	}
+	rec.Level = syslogSeverities[pri%8].String()
+	// A UTF-8 byte order mark may precede the message text.
+	rec.Message = strings.TrimPrefix(m[8], "\ufeff")
+	setPresent(&rec, "hostname", m[3])
+	setPresent(&rec, "app", m[4])
+	return rec, nil
+}
+
+// accessPattern matches the Apache/Nginx common and combined log formats:
+// host ident user [time] "request" status bytes ["referer" "user-agent"]
+var accessPattern = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// accessTimeLayout is the timestamp layout of access logs.
+const accessTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessFormat parses web server access logs. Requests answered with a
+// 5xx status are ERROR records, 4xx are WARNING and everything else INFO;
+// the message is the request line followed by the status and size. The
+// client host, user, referer and user agent are kept as the fields "host",
+// "user", "referer" and "user_agent".
+type accessFormat struct{}
+
+func (accessFormat) Parse(line string) (Record, error) {
+	raw := strings.TrimRight(line, "\r\n")
+	rec := Record{Raw: raw}
+	m := accessPattern.FindStringSubmatch(raw)
+	if m == nil {
+		return rec, fmt.Errorf("%w: not an access log line", ErrMalformed)
+	}
+	t, err := time.Parse(accessTimeLayout, m[3])
+	if err != nil {
+		return rec, fmt.Errorf("%w: bad timestamp: %v", ErrMalformed, err)
+	}
+	rec.Time = t
+	switch m[5][0] {
+	case '5':
+		rec.Level = SeverityError.String()
+	case '4':
+		rec.Level = SeverityWarning.String()
+	default:
+		rec.Level = SeverityInfo.String()
+	}
+	rec.Message = fmt.Sprintf("%s %s %s", m[4], m[5], m[6])
+	setPresent(&rec, "host", m[1])
+	setPresent(&rec, "user", m[2])
+	setPresent(&rec, "referer", m[7])
+	setPresent(&rec, "user_agent", m[8])
+	return rec, nil
+}
+
+// setPresent sets the field name of rec to value unless value is empty or
+// "-", which syslog and access logs write for missing values.
+func setPresent(rec *Record, name, value string) {
+	if value == "" || value == "-" {
+		return
+	}
+	if rec.Fields == nil {
+		rec.Fields = make(map[string]string)
+	}
+	rec.Fields[name] = value
+}
+
+// ErrUnknownFormat is returned by ResolveFormat for unregistered names.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ResolveFormat returns the format named name configured from p. The name
+// "auto" returns a nil Format, which asks the Filter to detect the format of
+// each input.
+func ResolveFormat(name string, p Parser) (Format, error) {
+	if name == "" || strings.EqualFold(name, "auto") {
+		return nil, nil
+	}
+	spec, ok := LookupFormat(name)
+	if !ok {
+		names := append([]string{"auto"}, FormatNames()...)
+		slices.Sort(names[1:])
+		return nil, fmt.Errorf("%w %q (want one of %s)", ErrUnknownFormat, name, strings.Join(names, ", "))
+	}
+	return spec.New(p), nil
+}
@@ -0,0 +1,97 @@
+package logfilter
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestJSONFormatKeepsNumbers(t *testing.T) {
+	f := jsonFormat{}
+	rec, err := f.Parse(`{"ts":1714528800,"level":"info","msg":"done","user_id":12345678,"bytes":1048576,"ratio":0.25,"big":12345678901234567890,"tags":[1,2.50]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"user_id": "12345678",
+		"bytes":   "1048576",
+		"ratio":   "0.25",
+		"big":     "12345678901234567890",
+		"tags":    "[1,2.50]",
+	} {
+		if got := rec.Fields[name]; got != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+	if rec.Time.Unix() != 1714528800 {
+		t.Errorf("time = %v, want Unix 1714528800", rec.Time)
+	}
+
+	q := mustQuery(t, "user_id = 12345678 AND bytes >= 1048576")
+	if !q.Match(rec) {
+		t.Errorf("%s does not match %v", q, rec.Fields)
+	}
+
+	if _, err := f.Parse(`{"level":"info","msg":"a"} trailing`); err == nil {
+		t.Error("trailing data after the object was accepted")
+	}
+}
+
+func TestFormatsCanonicalLevelsAndFields(t *testing.T) {
+	tests := []struct {
+		format     string
+		line       string
+		wantLevel  string
+		wantFields map[string]string
+	}{
+		{"plain", "2024-05-01 10:00:00 WARN disk almost full", "WARNING", nil},
+		{"plain", "2024-05-01 10:00:00 ERR disk full", "ERROR", nil},
+		{"plain", "2024-05-01 10:00:00 FATAL out of memory", "CRITICAL", nil},
+		{"plain", "2024-05-01 10:00:00 AUDIT login", "AUDIT", nil},
+		{"json", `{"level":"warn","msg":"slow"}`, "WARNING", nil},
+		{"json", `{"level":50,"msg":"failed"}`, "ERROR", nil},
+		{"logfmt", `level=Emerg msg="all down"`, "EMERGENCY", nil},
+		{
+			"syslog",
+			`<12>1 2024-05-01T10:00:00Z web01 nginx 4242 - - upstream slow`,
+			"WARNING",
+			map[string]string{"hostname": "web01", "app": "nginx"},
+		},
+		{"syslog", `<11>1 2024-05-01T10:00:00Z - - - - - no header values`, "ERROR", nil},
+		{
+			"access",
+			`10.0.0.7 - alice [01/May/2024:10:00:00 +0000] "GET /cart HTTP/1.1" 503 12 "https://shop.example/" "curl/8.5.0"`,
+			"ERROR",
+			map[string]string{"host": "10.0.0.7", "user": "alice", "referer": "https://shop.example/", "user_agent": "curl/8.5.0"},
+		},
+		{
+			"access",
+			`10.0.0.7 - - [01/May/2024:10:00:00 +0000] "GET / HTTP/1.1" 404 0`,
+			"WARNING",
+			map[string]string{"host": "10.0.0.7"},
+		},
+		{
+			"access",
+			`10.0.0.7 - - [01/May/2024:10:00:00 +0000] "GET / HTTP/1.1" 200 0 "-" "-"`,
+			"INFO",
+			map[string]string{"host": "10.0.0.7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+" "+tt.line, func(t *testing.T) {
+			f, err := ResolveFormat(tt.format, Parser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec, err := f.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Level != tt.wantLevel {
+				t.Errorf("level = %q, want %q", rec.Level, tt.wantLevel)
+			}
+			if !maps.Equal(rec.Fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", rec.Fields, tt.wantFields)
+			}
+		})
+	}
+}
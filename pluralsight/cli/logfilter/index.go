@@ -0,0 +1,429 @@
+package logfilter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// IndexSuffix is appended to a log file's name to form the path of its
+// sidecar index, e.g. app.log.lfidx.
+const IndexSuffix = ".lfidx"
+
+// indexVersion changes whenever the Index layout or the parsing of the
+// indexed records does, so stale sidecars written by older builds are
+// ignored instead of misread. Version 2 stores canonical level names.
+const indexVersion = 2
+
+// DefaultIndexBucket is the width of the time buckets of an index.
+const DefaultIndexBucket = time.Hour
+
+// maxTokenLen bounds the words kept in the token index; longer runs are
+// usually hashes or payloads nobody searches for by hand.
+const maxTokenLen = 64
+
+// Index is a sidecar index of one log file. Lines are identified by their
+// number (0-based), and Offsets maps them to byte offsets in the file.
+// Every posting list is sorted.
+type Index struct {
+	Version int
+
+	// Size and ModTime of the file when it was indexed. The index is only
+	// used while both still match, see Fresh.
+	Size    int64
+	ModTime time.Time
+
+	// The format and timestamp settings the lines were parsed with.
+	Format   string
+	Layout   string
+	Location string
+
+	Offsets []int64
+	Invalid []uint32            // lines that could not be parsed
+	Levels  map[string][]uint32 // lines per level name
+	Bucket  time.Duration
+	Buckets []IndexBucket
+
+	// The token index: the lines containing Words[i], a lower-cased word
+	// of the message, are Postings[Starts[i]:Starts[i+1]]. Words is sorted.
+	// Flat slices decode far faster than a map with one entry per word.
+	Words    []string
+	Starts   []uint32
+	Postings []uint32
+}
+
+// IndexBucket spans the lines whose timestamps fall in [Start, Start+Bucket).
+// Logs are not always in order, so First and Last bound the lines rather
+// than listing them.
+type IndexBucket struct {
+	Start       time.Time
+	First, Last uint32
+}
+
+// IndexPath returns the sidecar index path of the named log file.
+func IndexPath(name string) string { return name + IndexSuffix }
+
+// BuildIndex indexes the named file. A nil format is detected from the
+// first lines, as Filter.AutoDetect does; p configures the timestamps.
+func BuildIndex(name string, format Format, p Parser, bucket time.Duration) (*Index, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 4)
+	n, _ := file.ReadAt(head, 0)
+	if IsCompressed(head[:n]) {
+		return nil, fmt.Errorf("%s: compressed files cannot be indexed", name)
+	}
+	if bucket <= 0 {
+		bucket = DefaultIndexBucket
+	}
+
+	formatName := ""
+	if format == nil {
+		formatName, format = detectAt(file, p)
+	} else if formatName = formatNameOf(format, p); formatName == "" {
+		return nil, fmt.Errorf("%s: only registered formats can be indexed", name)
+	}
+	idx := &Index{
+		Version:  indexVersion,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Format:   formatName,
+		Layout:   p.layout(),
+		Location: p.location().String(),
+		Levels:   map[string][]uint32{},
+		Bucket:   bucket,
+	}
+
+	buckets := map[time.Time]*IndexBucket{}
+	tokens := map[string][]uint32{}
+	var off int64
+	br := bufio.NewReader(file)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			n := uint32(len(idx.Offsets))
+			idx.Offsets = append(idx.Offsets, off)
+			off += int64(len(line))
+
+			rec, perr := format.Parse(strings.TrimRight(line, "\r\n"))
+			if perr != nil {
+				idx.Invalid = append(idx.Invalid, n)
+			} else {
+				idx.Levels[rec.Level] = append(idx.Levels[rec.Level], n)
+				start := rec.Time.Truncate(bucket)
+				if b, ok := buckets[start]; ok {
+					b.Last = n
+				} else {
+					buckets[start] = &IndexBucket{Start: start, First: n, Last: n}
+				}
+				for _, w := range tokenize(rec.Message) {
+					// A word repeated within a line is only listed once.
+					if l := tokens[w]; len(l) == 0 || l[len(l)-1] != n {
+						tokens[w] = append(l, n)
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range buckets {
+		idx.Buckets = append(idx.Buckets, *b)
+	}
+	slices.SortFunc(idx.Buckets, func(a, b IndexBucket) int { return a.Start.Compare(b.Start) })
+
+	idx.Words = slices.Sorted(maps.Keys(tokens))
+	idx.Starts = make([]uint32, 0, len(idx.Words)+1)
+	for _, w := range idx.Words {
+		idx.Starts = append(idx.Starts, uint32(len(idx.Postings)))
+		idx.Postings = append(idx.Postings, tokens[w]...)
+	}
+	idx.Starts = append(idx.Starts, uint32(len(idx.Postings)))
+	return idx, nil
+}
+
+// WriteIndex saves idx as the sidecar of the named file. It is written to
+// a temporary file first, so readers never see a partial index.
+func WriteIndex(name string, idx *Index) error {
+	path := IndexPath(name)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".lfidx-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	if err := gob.NewEncoder(zw).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file private; an index is as readable as a log.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadIndex loads the sidecar index of the named file.
+func ReadIndex(name string) (*Index, error) {
+	file, err := os.Open(IndexPath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := gob.NewDecoder(zr).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("index version %d, want %d", idx.Version, indexVersion)
+	}
+	return &idx, nil
+}
+
+// Fresh reports whether idx still describes a file with the given info.
+func (idx *Index) Fresh(info os.FileInfo) bool {
+	return idx.Size == info.Size() && idx.ModTime.Equal(info.ModTime())
+}
+
+// Lines returns the number of indexed lines.
+func (idx *Index) Lines() int { return len(idx.Offsets) }
+
+// wordLines returns the lines whose message contains word.
+func (idx *Index) wordLines(word string) []uint32 {
+	i, ok := slices.BinarySearch(idx.Words, word)
+	if !ok {
+		return nil
+	}
+	return idx.Postings[idx.Starts[i]:idx.Starts[i+1]]
+}
+
+// candidates returns the lines that may satisfy every matcher, or false
+// when no matcher can be answered from the index. The set is a superset:
+// the lines are still parsed and matched, so unsupported parts of a query
+// only make it larger.
+func (idx *Index) candidates(matchers []Matcher) ([]uint32, bool) {
+	var set []uint32
+	found := false
+	for _, m := range matchers {
+		s, ok := idx.matcherLines(m)
+		if !ok {
+			continue
+		}
+		if found {
+			set = intersectLines(set, s)
+		} else {
+			set, found = s, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	// Unparsable lines never match but are still counted as invalid.
+	return unionLines(set, idx.Invalid), true
+}
+
+func (idx *Index) matcherLines(m Matcher) ([]uint32, bool) {
+	switch m := m.(type) {
+	case LevelIs:
+		return idx.Levels[string(m)], true
+	case LevelRange:
+		return idx.levelLines(m.Contains), true
+	case TimeRange:
+		return idx.timeLines(m.Since, m.Until), true
+	case Query:
+		return idx.queryLines(m)
+	}
+	return nil, false
+}
+
+func (idx *Index) queryLines(q Query) ([]uint32, bool) {
+	switch q := q.(type) {
+	case andQuery:
+		l, lok := idx.queryLines(q.left)
+		r, rok := idx.queryLines(q.right)
+		switch {
+		case lok && rok:
+			return intersectLines(l, r), true
+		case lok:
+			return l, true
+		case rok:
+			return r, true
+		}
+	case orQuery:
+		l, lok := idx.queryLines(q.left)
+		r, rok := idx.queryLines(q.right)
+		if lok && rok {
+			return unionLines(l, r), true
+		}
+	case *compareQuery:
+		return idx.compareLines(q)
+	}
+	return nil, false
+}
+
+func (idx *Index) compareLines(c *compareQuery) ([]uint32, bool) {
+	switch {
+	case c.field.kind == fieldLevel && c.op == "=":
+		return idx.levelLines(c.levelEqual), true
+	case c.field.kind == fieldLevel && isOrdering(c.op):
+		return idx.levelLines(func(level string) bool {
+			sev, err := ParseSeverity(level)
+			return err == nil && compareOrdered(int(sev)-int(c.sev), c.op)
+		}), true
+	case c.field.kind == fieldTime && (c.op == ">" || c.op == ">="):
+		return idx.timeLines(c.time, time.Time{}), true
+	case c.field.kind == fieldTime && (c.op == "<" || c.op == "<="):
+		// The bucket holding the bound itself is included by timeLines.
+		return idx.timeLines(time.Time{}, c.time.Add(time.Nanosecond)), true
+	case c.field.name == "message" && c.op == "has":
+		set := idx.wordLines(c.words[0])
+		for _, w := range c.words[1:] {
+			set = intersectLines(set, idx.wordLines(w))
+		}
+		return set, true
+	}
+	return nil, false
+}
+
+// levelLines returns the lines of every indexed level accepted by keep.
+func (idx *Index) levelLines(keep func(level string) bool) []uint32 {
+	var set []uint32
+	for level, lines := range idx.Levels {
+		if keep(level) {
+			set = unionLines(set, lines)
+		}
+	}
+	return set
+}
+
+// timeLines returns the lines of every bucket overlapping [since, until).
+// Zero bounds are open.
+func (idx *Index) timeLines(since, until time.Time) []uint32 {
+	var set []uint32
+	for _, b := range idx.Buckets {
+		if !since.IsZero() && !b.Start.Add(idx.Bucket).After(since) {
+			continue
+		}
+		if !until.IsZero() && !b.Start.Before(until) {
+			continue
+		}
+		span := make([]uint32, 0, b.Last-b.First+1)
+		for n := b.First; n <= b.Last; n++ {
+			span = append(span, n)
+		}
+		set = unionLines(set, span)
+	}
+	return set
+}
+
+// intersectLines returns the lines present in both sorted lists.
+func intersectLines(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// unionLines merges two sorted lists without duplicates.
+func unionLines(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
+
+// tokenize splits s into lower-cased words of letters and digits, the unit
+// of the token index and of the query operator "has".
+func tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return slices.DeleteFunc(words, func(w string) bool { return len(w) > maxTokenLen })
+}
+
+// hasWords reports whether every word occurs in s as a whole word.
+func hasWords(s string, words []string) bool {
+	have := tokenize(s)
+	for _, w := range words {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatNameOf returns the registered name of format as built for p, or ""
+// when it is not a registered format.
+func formatNameOf(format Format, p Parser) string {
+	for _, name := range FormatNames() {
+		spec, _ := LookupFormat(name)
+		if reflect.DeepEqual(spec.New(p), format) {
+			return name
+		}
+	}
+	return ""
+}
+
+// detectAt detects the format of a file from the lines at its start.
+func detectAt(r io.ReaderAt, p Parser) (string, Format) {
+	head := make([]byte, 64<<10)
+	n, _ := r.ReadAt(head, 0)
+	lines := strings.SplitN(string(head[:n]), "\n", DetectSampleSize+1)
+	return DetectFormat(lines[:min(len(lines), DetectSampleSize)], p)
+}
@@ -0,0 +1,200 @@
+package logfilter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var indexTestStart = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+// indexTestLog returns a log of n lines spread over a few hours, with a
+// mix of levels, messages and unparsable lines.
+func indexTestLog(n int) string {
+	levels := []string{"INFO", "INFO", "DEBUG", "WARN", "INFO", "ERROR", "INFO", "CRITICAL"}
+	messages := []string{"request served in 12ms", "disk timeout on sda", "payment declined for order 7", "cache miss for user 42"}
+	var b strings.Builder
+	for i := range n {
+		if i%50 == 49 {
+			b.WriteString("not a log line\n")
+			continue
+		}
+		ts := indexTestStart.Add(time.Duration(i) * 37 * time.Second)
+		fmt.Fprintf(&b, "%s %s %s\n", ts.Format(DefaultTimeLayout), levels[i*7%len(levels)], messages[i/3%len(messages)])
+	}
+	return b.String()
+}
+
+// writeIndexedLog writes text to a file and indexes it with p.
+func writeIndexedLog(t *testing.T, text string, p Parser) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildIndex(path, nil, p, DefaultIndexBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteIndex(path, idx); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// indexRun is what filtering a file produced.
+type indexRun struct {
+	lines   []string
+	invalid int
+	indexed bool // the sidecar index was used
+}
+
+func runWithIndex(t *testing.T, path string, matchers []Matcher, ignoreIndex bool) indexRun {
+	t.Helper()
+	sink := &recordSink{}
+	f := &Filter{Matchers: matchers, Sink: sink, IgnoreIndex: ignoreIndex}
+	var run indexRun
+	if !ignoreIndex {
+		var err error
+		if run.indexed, err = f.runIndexed(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !run.indexed {
+		if err := f.RunFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rec := range sink.records {
+		run.lines = append(run.lines, rec.Raw)
+	}
+	run.invalid = f.Invalid()
+	return run
+}
+
+// compareIndexed checks that matchers give the same records and invalid
+// line count with and without the index of path.
+func compareIndexed(t *testing.T, path string, matchers []Matcher) (indexed, scanned indexRun) {
+	t.Helper()
+	indexed = runWithIndex(t, path, matchers, false)
+	scanned = runWithIndex(t, path, matchers, true)
+	if !slices.Equal(indexed.lines, scanned.lines) {
+		t.Errorf("with the index:\n%s\nwithout:\n%s", strings.Join(indexed.lines, "\n"), strings.Join(scanned.lines, "\n"))
+	}
+	if indexed.invalid != scanned.invalid {
+		t.Errorf("invalid lines = %d with the index, %d without", indexed.invalid, scanned.invalid)
+	}
+	return indexed, scanned
+}
+
+func TestIndexMatchesFullScan(t *testing.T) {
+	path := writeIndexedLog(t, indexTestLog(400), Parser{})
+	hour := indexTestStart.Add(time.Hour)
+	tests := []struct {
+		name        string
+		matchers    []Matcher
+		wantIndexed bool
+	}{
+		{"level", []Matcher{LevelIs("ERROR")}, true},
+		{"canonical level", []Matcher{LevelIs("WARNING")}, true},
+		{"level range", []Matcher{LevelRange{Min: SeverityError, Max: SeverityEmergency}}, true},
+		{"time range", []Matcher{TimeRange{Since: hour, Until: hour.Add(30 * time.Minute)}}, true},
+		{"open time range", []Matcher{TimeRange{Since: indexTestStart.Add(3 * time.Hour)}}, true},
+		{"level and time", []Matcher{LevelIs("ERROR"), TimeRange{Until: hour}}, true},
+		{"word", []Matcher{mustQuery(t, "message has timeout")}, true},
+		{"level alias in a query", []Matcher{mustQuery(t, "level = warn")}, true},
+		{"AND", []Matcher{mustQuery(t, "level >= ERROR AND message has payment")}, true},
+		{"OR", []Matcher{mustQuery(t, "message has declined OR level = CRITICAL")}, true},
+		{"substring", []Matcher{mustQuery(t, "message contains clined")}, false},
+		{"NOT", []Matcher{mustQuery(t, "NOT level = INFO")}, false},
+		{"no matches", []Matcher{mustQuery(t, "message has nothing")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexed, scanned := compareIndexed(t, path, tt.matchers)
+			if tt.wantIndexed && !indexed.indexed {
+				t.Error("the index was not used")
+			}
+			if tt.name != "no matches" && len(scanned.lines) == 0 {
+				t.Error("the query matches nothing; pick one that does")
+			}
+		})
+	}
+}
+
+func TestIndexIgnoredWhenStale(t *testing.T) {
+	matchers := []Matcher{LevelIs("ERROR")}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, path string)
+	}{
+		{"appended lines", func(t *testing.T, path string) {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString("2024-05-01 12:00:00 ERROR appended after indexing\n"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"rewritten in place", func(t *testing.T, path string) {
+			// Same size, so only the modification time gives it away.
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = []byte(strings.ReplaceAll(string(data), "DEBUG", "ERROR"))
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeIndexedLog(t, indexTestLog(400), Parser{})
+			before := runWithIndex(t, path, matchers, true)
+			tt.change(t, path)
+			indexed, scanned := compareIndexed(t, path, matchers)
+			if indexed.indexed {
+				t.Error("a stale index was used")
+			}
+			if len(scanned.lines) <= len(before.lines) {
+				t.Errorf("%d matches after the change, %d before; the change added none", len(scanned.lines), len(before.lines))
+			}
+		})
+	}
+}
+
+func TestIndexIgnoredForOtherTimestampSettings(t *testing.T) {
+	// Each index is built with settings that put the lines at other times,
+	// so using it would select the wrong buckets.
+	tests := []struct {
+		name   string
+		parser Parser
+	}{
+		{"layout", Parser{Layout: "2006-02-01 15:04:05"}}, // day and month swapped
+		{"location", Parser{Location: time.FixedZone("UTC+5", 5*60*60)}},
+	}
+	matchers := []Matcher{TimeRange{Since: indexTestStart.Add(time.Hour), Until: indexTestStart.Add(90 * time.Minute)}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeIndexedLog(t, indexTestLog(400), tt.parser)
+			indexed, scanned := compareIndexed(t, path, matchers)
+			if indexed.indexed {
+				t.Error("an index built with other timestamp settings was used")
+			}
+			if len(scanned.lines) == 0 {
+				t.Error("the time range matches nothing")
+			}
+		})
+	}
+}
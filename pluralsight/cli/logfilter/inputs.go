@@ -0,0 +1,146 @@
+package logfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StdinName is the input name that selects standard input, as in most Unix tools.
+const StdinName = "-"
+
+// stdinLabel is printed instead of "-" when output lines are prefixed with
+// their source, matching grep's wording.
+const stdinLabel = "(standard input)"
+
+// ExpandInputs turns command-line style arguments into the list of inputs
+// to read. Each argument may be:
+//   - "-" for standard input,
+//   - a shell-style glob such as "logs/*.txt" (see filepath.Match),
+//   - a directory, whose regular files are read when recursive is true,
+//   - or a plain file path.
+//
+// Inputs are returned in argument order; glob and directory matches are
+// sorted lexically. Sidecar index files (see IndexPath) found by a glob or
+// directory walk are skipped.
+func ExpandInputs(args []string, recursive bool) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		if arg == StdinName {
+			inputs = append(inputs, arg)
+			continue
+		}
+
+		paths := []string{arg}
+		if hasGlobMeta(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no files match pattern", arg)
+			}
+			paths = matches
+		}
+
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if p == arg || !strings.HasSuffix(p, IndexSuffix) {
+					inputs = append(inputs, p)
+				}
+				continue
+			}
+			if !recursive {
+				return nil, fmt.Errorf("%s: is a directory (use -r to read it recursively)", p)
+			}
+			files, err := walkFiles(p)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, files...)
+		}
+	}
+	return inputs, nil
+}
+
+// walkFiles returns every regular file below root. filepath.WalkDir visits
+// entries in lexical order, so the result is deterministic.
+func walkFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && !strings.HasSuffix(path, IndexSuffix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// hasGlobMeta reports whether path contains any of the special characters
+// recognised by filepath.Match.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// OpenInput opens a named input for reading. "-" returns standard input,
+// wrapped so that closing it is a no-op. Compressed inputs are detected by
+// their content and decompressed transparently (see Decompress).
+func OpenInput(name string) (io.ReadCloser, error) {
+	var file io.ReadCloser = io.NopCloser(os.Stdin)
+	if name != StdinName {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+
+	dec, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", DisplayName(name), err)
+	}
+	return &readCloser{Reader: dec, closers: []io.Closer{dec, file}}, nil
+}
+
+// DisplayName returns the label used for an input in prefixed output.
+func DisplayName(name string) string {
+	if name == StdinName {
+		return stdinLabel
+	}
+	return name
+}
+
+// ScanLines calls fn for every line read from r, without the trailing
+// newline. A final line that is not terminated by a newline is still passed
+// to fn. Scanning stops at the first error returned by fn.
+func ScanLines(r io.Reader, fn func(line string) error) error {
+	// bufio.NewReader wraps the reader, providing a buffered reading mechanism.
+	// ReadString is used instead of bufio.Scanner so very long lines are not rejected.
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if ferr := fn(strings.TrimRight(line, "\r\n")); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package logfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KafkaMessage is a message to publish to a Kafka topic.
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// DeliveryError reports a message the broker did not accept.
+type DeliveryError struct {
+	Message KafkaMessage
+	Err     error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("delivery to %s failed: %v", e.Message.Topic, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// ErrUndelivered is the Err of a DeliveryError for a message still queued
+// when KafkaSink gave up waiting for it.
+var ErrUndelivered = errors.New("not delivered before the flush timeout")
+
+// Producer publishes messages to Kafka. It wraps a client library such as
+// confluent-kafka-go, whose producers queue messages and deliver them in
+// the background.
+type Producer interface {
+	// Produce queues msg for delivery.
+	Produce(msg KafkaMessage) error
+	// Flush waits up to timeout for the queued messages to be delivered.
+	// It returns the delivery failures seen since the last Flush and the
+	// number of messages still queued.
+	Flush(timeout time.Duration) (failed []DeliveryError, queued int)
+	// Close releases the client. Messages still queued are dropped.
+	Close() error
+}
+
+// DefaultKafkaBatch is the default KafkaSink.BatchSize.
+const DefaultKafkaBatch = 100
+
+// DefaultKafkaTimeout is the default KafkaSink.Timeout.
+const DefaultKafkaTimeout = 10 * time.Second
+
+// KafkaSink publishes every record as a JSON object (the same one written
+// by the ndjson output) to a Kafka topic, keyed by the record's source so
+// the records of one input stay in order on one partition.
+//
+// Records are queued with the Producer and, every BatchSize records, the
+// sink waits for the batch to be delivered, so a slow broker slows the
+// filter down instead of letting the queue grow without bounds. Failed
+// deliveries are passed to OnError and counted; they do not stop the sink.
+type KafkaSink struct {
+	Producer Producer
+	Topic    string
+
+	// Fields, if set, selects the published fields as in SinkOptions.
+	Fields []string
+
+	// BatchSize is the number of records queued before waiting for their
+	// delivery. Zero means DefaultKafkaBatch.
+	BatchSize int
+
+	// Timeout bounds each wait for deliveries. Zero means
+	// DefaultKafkaTimeout.
+	Timeout time.Duration
+
+	// OnError, if set, is called for every failed delivery.
+	OnError func(err *DeliveryError)
+
+	mu      sync.Mutex
+	queued  int
+	failed  int
+	written int
+}
+
+// Write implements Sink.
+func (s *KafkaSink) Write(rec Record) error {
+	var value []byte
+	var err error
+	if s.Fields != nil {
+		value, err = selectedJSON(rec, s.Fields)
+	} else {
+		value, err = json.Marshal(toJSONRecord(rec))
+	}
+	if err != nil {
+		return err
+	}
+	msg := KafkaMessage{Topic: s.Topic, Key: []byte(rec.Source), Value: value}
+	if err := s.Producer.Produce(msg); err != nil {
+		return fmt.Errorf("kafka: %w", err)
+	}
+
+	s.mu.Lock()
+	s.queued++
+	s.written++
+	full := s.queued >= s.batchSize()
+	s.mu.Unlock()
+	if full {
+		s.wait(s.timeout(), false)
+	}
+	return nil
+}
+
+func (s *KafkaSink) batchSize() int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return DefaultKafkaBatch
+}
+
+func (s *KafkaSink) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultKafkaTimeout
+}
+
+// wait flushes the producer and reports failed deliveries. With final
+// set, messages still queued afterwards count as failed as well.
+func (s *KafkaSink) wait(timeout time.Duration, final bool) {
+	failed, queued := s.Producer.Flush(timeout)
+	s.mu.Lock()
+	s.queued = queued
+	s.failed += len(failed)
+	if final {
+		s.failed += queued
+	}
+	s.mu.Unlock()
+	for i := range failed {
+		s.report(&failed[i])
+	}
+	if final && queued > 0 {
+		s.report(&DeliveryError{Message: KafkaMessage{Topic: s.Topic}, Err: fmt.Errorf("%d message(s) %w", queued, ErrUndelivered)})
+	}
+}
+
+func (s *KafkaSink) report(err *DeliveryError) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// Flush implements Flusher. It collects the delivery reports that have
+// arrived without waiting for the rest, so a followed file is published
+// as it grows: the producer delivers in the background anyway.
+func (s *KafkaSink) Flush() error {
+	s.wait(0, false)
+	return nil
+}
+
+// Close waits for the queued records to be delivered and closes the
+// producer.
+func (s *KafkaSink) Close() error {
+	s.wait(s.timeout(), true)
+	return s.Producer.Close()
+}
+
+// Failed returns the number of records that could not be delivered.
+func (s *KafkaSink) Failed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+// Written returns the number of records handed to the producer.
+func (s *KafkaSink) Written() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
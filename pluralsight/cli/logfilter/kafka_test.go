@@ -0,0 +1,162 @@
+package logfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryProducer is a Producer that keeps the messages in memory. Like a
+// real client it reports delivery failures at the next Flush rather than
+// from Produce. Messages for which fail returns an error fail; with hold
+// set, messages stay queued instead of being delivered.
+type memoryProducer struct {
+	fail func(msg KafkaMessage) error
+	hold bool
+
+	delivered []KafkaMessage
+	queued    []KafkaMessage
+	failed    []DeliveryError
+	flushes   []int // messages queued at each Flush
+	closed    bool
+}
+
+func (p *memoryProducer) Produce(msg KafkaMessage) error {
+	if p.closed {
+		return errors.New("producer is closed")
+	}
+	p.queued = append(p.queued, msg)
+	return nil
+}
+
+func (p *memoryProducer) Flush(time.Duration) ([]DeliveryError, int) {
+	p.flushes = append(p.flushes, len(p.queued))
+	if p.hold {
+		failed := p.failed
+		p.failed = nil
+		return failed, len(p.queued)
+	}
+	for _, msg := range p.queued {
+		if p.fail != nil {
+			if err := p.fail(msg); err != nil {
+				p.failed = append(p.failed, DeliveryError{Message: msg, Err: err})
+				continue
+			}
+		}
+		p.delivered = append(p.delivered, msg)
+	}
+	p.queued = nil
+	failed := p.failed
+	p.failed = nil
+	return failed, 0
+}
+
+func (p *memoryProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestKafkaSinkBatchesAndEncodes(t *testing.T) {
+	p := &memoryProducer{}
+	sink := &KafkaSink{Producer: p, Topic: "logs", BatchSize: 3}
+	f := &Filter{Sink: sink}
+	if err := f.Run(strings.NewReader(readLogTxt(t)), "log.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// Eight records: a Flush after the 3rd and 6th, and one on Close for
+	// the last two.
+	if want := []int{3, 3}; !slices.Equal(p.flushes, want) {
+		t.Errorf("flushes before Close = %v, want %v", p.flushes, want)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 3, 2}; !slices.Equal(p.flushes, want) {
+		t.Errorf("flushes = %v, want %v", p.flushes, want)
+	}
+	if !p.closed {
+		t.Error("Close did not close the producer")
+	}
+	if len(p.delivered) != 8 || sink.Written() != 8 || sink.Failed() != 0 {
+		t.Fatalf("delivered %d, Written %d, Failed %d; want 8, 8, 0", len(p.delivered), sink.Written(), sink.Failed())
+	}
+
+	msg := p.delivered[1]
+	if msg.Topic != "logs" || string(msg.Key) != "log.txt" {
+		t.Errorf("message topic %q key %q, want logs and log.txt", msg.Topic, msg.Key)
+	}
+	var got jsonRecord
+	if err := json.Unmarshal(msg.Value, &got); err != nil {
+		t.Fatalf("value %s: %v", msg.Value, err)
+	}
+	want := jsonRecord{Time: "1970-01-01T00:00:00Z", Level: "EMERGENCY", Message: "Failed to connect to database", Source: "log.txt"}
+	if got.Time != want.Time || got.Level != want.Level || got.Message != want.Message || got.Source != want.Source {
+		t.Errorf("value = %+v, want %+v", got, want)
+	}
+}
+
+func TestKafkaSinkSelectedFields(t *testing.T) {
+	p := &memoryProducer{}
+	sink := &KafkaSink{Producer: p, Topic: "logs", Fields: []string{"level", "user"}}
+	if err := sink.Write(Record{Level: "ERROR", Source: "a.log"}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+	if got := string(p.delivered[0].Value); got != `{"level":"ERROR","user":null}` {
+		t.Errorf("value = %s", got)
+	}
+}
+
+func TestKafkaSinkReportsFailures(t *testing.T) {
+	p := &memoryProducer{fail: func(msg KafkaMessage) error {
+		if strings.Contains(string(msg.Value), "CRITICAL") {
+			return errors.New("broker down")
+		}
+		return nil
+	}}
+	var reported []*DeliveryError
+	sink := &KafkaSink{Producer: p, Topic: "logs", BatchSize: 4, OnError: func(err *DeliveryError) { reported = append(reported, err) }}
+	f := &Filter{Sink: sink}
+	if err := f.Run(strings.NewReader(readLogTxt(t)), "log.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Failed() != 2 || len(reported) != 2 || len(p.delivered) != 6 {
+		t.Fatalf("Failed %d, reported %d, delivered %d; want 2, 2, 6", sink.Failed(), len(reported), len(p.delivered))
+	}
+	for _, err := range reported {
+		if err.Err.Error() != "broker down" || !strings.Contains(string(err.Message.Value), "CRITICAL") {
+			t.Errorf("reported %v for %s", err, err.Message.Value)
+		}
+	}
+}
+
+func TestKafkaSinkUndeliveredOnClose(t *testing.T) {
+	p := &memoryProducer{hold: true}
+	var reported []*DeliveryError
+	sink := &KafkaSink{Producer: p, Topic: "logs", BatchSize: 10, OnError: func(err *DeliveryError) { reported = append(reported, err) }}
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := sink.Write(Record{Level: "INFO", Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Flush only collects reports; nothing is undelivered yet.
+	sink.Flush()
+	if sink.Failed() != 0 || len(reported) != 0 {
+		t.Fatalf("after Flush: Failed %d, reported %d; want 0, 0", sink.Failed(), len(reported))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Failed() != 3 || sink.Written() != 3 {
+		t.Errorf("Failed %d, Written %d; want 3, 3", sink.Failed(), sink.Written())
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrUndelivered) {
+		t.Errorf("reported %v, want one ErrUndelivered", reported)
+	}
+}
@@ -0,0 +1,114 @@
+package logfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is the rank of a log level. Higher values are more severe, so
+// "WARNING and above" is simply sev >= SeverityWarning.
+type Severity int
+
+// The levels seen in log.txt, in increasing order of severity. They follow
+// the syslog severities (RFC 5424) with the numbering reversed.
+const (
+	SeverityUnknown Severity = iota - 1
+	SeverityDebug
+	SeverityInfo
+	SeverityNotice
+	SeverityWarning
+	SeverityError
+	SeverityCritical
+	SeverityAlert
+	SeverityEmergency
+)
+
+// severityNames maps each Severity to its canonical level name.
+var severityNames = [...]string{
+	SeverityDebug:     "DEBUG",
+	SeverityInfo:      "INFO",
+	SeverityNotice:    "NOTICE",
+	SeverityWarning:   "WARNING",
+	SeverityError:     "ERROR",
+	SeverityCritical:  "CRITICAL",
+	SeverityAlert:     "ALERT",
+	SeverityEmergency: "EMERGENCY",
+}
+
+// severityAliases lists common short spellings accepted on the command line
+// and in log files.
+var severityAliases = map[string]Severity{
+	"WARN":  SeverityWarning,
+	"ERR":   SeverityError,
+	"CRIT":  SeverityCritical,
+	"EMERG": SeverityEmergency,
+}
+
+// String returns the canonical name of the severity.
+func (s Severity) String() string {
+	if s < SeverityDebug || int(s) >= len(severityNames) {
+		return "UNKNOWN"
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity converts a level name such as "warning" or "WARN" into a
+// Severity. Matching is case-insensitive.
+func ParseSeverity(name string) (Severity, error) {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	for i, n := range severityNames {
+		if n == upper {
+			return Severity(i), nil
+		}
+	}
+	if s, ok := severityAliases[upper]; ok {
+		return s, nil
+	}
+	return SeverityUnknown, fmt.Errorf("unknown level %q (want one of %s)", name, strings.Join(severityNames[:], ", "))
+}
+
+// LevelRange matches records whose severity lies between Min and Max,
+// inclusive. Levels outside the known hierarchy never match.
+type LevelRange struct {
+	Min, Max Severity
+}
+
+// Match implements Matcher.
+func (r LevelRange) Match(rec Record) bool { return r.Contains(rec.Level) }
+
+// Contains reports whether the given level name falls inside the range.
+func (r LevelRange) Contains(level string) bool {
+	s, err := ParseSeverity(level)
+	if err != nil {
+		return false
+	}
+	return s >= r.Min && s <= r.Max
+}
+
+// ParseLevelRange builds a LevelRange from the names of its lowest and
+// highest level. An empty name leaves that side of the range open.
+func ParseLevelRange(lo, hi string) (LevelRange, error) {
+	r := LevelRange{Min: SeverityDebug, Max: SeverityEmergency}
+	var err error
+	if lo != "" {
+		if r.Min, err = ParseSeverity(lo); err != nil {
+			return r, fmt.Errorf("minimum level: %w", err)
+		}
+	}
+	if hi != "" {
+		if r.Max, err = ParseSeverity(hi); err != nil {
+			return r, fmt.Errorf("maximum level: %w", err)
+		}
+	}
+	if r.Min > r.Max {
+		return r, fmt.Errorf("minimum level %s is above maximum level %s", r.Min, r.Max)
+	}
+	return r, nil
+}
+
+// LevelIs matches records whose level is exactly level, the behaviour of
+// the CLI's -level flag.
+type LevelIs string
+
+// Match implements Matcher.
+func (l LevelIs) Match(rec Record) bool { return rec.Level == string(l) }
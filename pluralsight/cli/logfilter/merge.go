@@ -0,0 +1,209 @@
+package logfilter
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// MergeInput is one input of Filter.RunMerged.
+type MergeInput struct {
+	// Name is a file path or StdinName.
+	Name string
+
+	// Offset is added to every timestamp of the input before it is
+	// matched and sorted, to correct a clock that runs behind (positive
+	// Offset) or ahead. Text output still shows the original lines.
+	Offset time.Duration
+}
+
+// RunMerged filters several inputs as one stream sorted by time, like
+// `sort -m` on already sorted files: it keeps the next kept record of each
+// input in a heap and always writes the earliest one, so only one record
+// per input is held in memory. Each input has its format detected on its
+// own (with AutoDetect) and its clock corrected by its Offset. Lines that
+// cannot be parsed stay behind the record before them in the same input.
+// Records with equal times are written in input order.
+//
+// Indexes, parallel scanning and context records are not used.
+func (f *Filter) RunMerged(inputs []MergeInput) error {
+	h := &mergeHeap{}
+	var sources []*mergeSource
+	defer func() {
+		for _, s := range sources {
+			s.closer.Close()
+		}
+	}()
+	for i, in := range inputs {
+		s, err := f.openMergeSource(in, i)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, s)
+		ok, err := s.advance(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Push(h, s)
+		}
+	}
+
+	for h.Len() > 0 {
+		s := (*h)[0]
+		f.mu.Lock()
+		err := f.record(s.head, false)
+		f.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		ok, err := s.advance(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// mergeSource reads the records of one merged input on demand.
+type mergeSource struct {
+	MergeInput
+	source  string // display name
+	order   int    // position among the inputs, to break ties
+	r       *bufio.Reader
+	closer  io.Closer
+	eof     bool
+	readErr error
+
+	format  Format
+	isStart func(line string) bool // nil unless Filter.Multiline
+
+	buf  []string   // lines read but not consumed yet
+	head lineResult // the next record to write
+	time time.Time  // sort key of head
+	last time.Time  // time of the last parsed record, for invalid lines
+}
+
+func (f *Filter) openMergeSource(in MergeInput, order int) (*mergeSource, error) {
+	file, err := OpenInput(in.Name)
+	if err != nil {
+		return nil, err
+	}
+	s := &mergeSource{
+		MergeInput: in,
+		source:     DisplayName(in.Name),
+		order:      order,
+		r:          bufio.NewReader(file),
+		closer:     file,
+	}
+	s.format = f.format()
+	if s.format == nil {
+		for len(s.buf) < DetectSampleSize && s.fill() {
+		}
+		if s.readErr != nil {
+			file.Close()
+			return nil, s.readErr
+		}
+		_, s.format = DetectFormat(s.buf, f.Parser)
+	}
+	if f.Multiline {
+		s.isStart = RecordStartFunc(s.format, f.RecordStart)
+	}
+	return s, nil
+}
+
+// fill reads one more line into buf. It reports false at the end of the
+// input or on a read error, which is kept in readErr.
+func (s *mergeSource) fill() bool {
+	if s.eof {
+		return false
+	}
+	line, err := s.r.ReadString('\n')
+	if line != "" {
+		s.buf = append(s.buf, strings.TrimRight(line, "\r\n"))
+	}
+	if err != nil {
+		s.eof = true
+		if err != io.EOF {
+			s.readErr = fmt.Errorf("%s: %w", s.source, err)
+		}
+	}
+	return line != ""
+}
+
+// nextText returns the text of the next record: one line, or with
+// Multiline a line and its continuation lines joined with "\n".
+func (s *mergeSource) nextText() (string, bool) {
+	if len(s.buf) == 0 && !s.fill() {
+		return "", false
+	}
+	lines := []string{s.buf[0]}
+	s.buf = s.buf[1:]
+	if s.isStart != nil {
+		for (len(s.buf) > 0 || s.fill()) && !s.isStart(s.buf[0]) {
+			lines = append(lines, s.buf[0])
+			s.buf = s.buf[1:]
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// advance moves head to the next record of the input that f keeps. It
+// reports false at the end of the input.
+func (s *mergeSource) advance(f *Filter) (bool, error) {
+	for {
+		text, ok := s.nextText()
+		if !ok {
+			return false, s.readErr
+		}
+		// This is evaluate with the clock correction applied before the
+		// matchers, so -since and -until see corrected times.
+		rec, err := ParseRecord(s.format, text)
+		rec.Source = s.source
+		if err != nil {
+			s.head, s.time = lineResult{rec: rec, err: err}, s.last
+			return true, nil
+		}
+		rec.Time = rec.Time.Add(s.Offset)
+		s.last = rec.Time
+		for _, x := range f.Extractors {
+			x.Extract(&rec)
+		}
+		if f.Match(rec) {
+			s.head, s.time = lineResult{rec: rec}, rec.Time
+			return true, nil
+		}
+	}
+}
+
+// mergeHeap orders the inputs by the time of their next record. It
+// implements heap.Interface.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if c := h[i].time.Compare(h[j].time); c != 0 {
+		return c < 0
+	}
+	return h[i].order < h[j].order
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
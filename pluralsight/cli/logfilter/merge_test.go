@@ -0,0 +1,177 @@
+package logfilter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMerged(t *testing.T) {
+	type input struct {
+		name   string
+		text   string
+		offset time.Duration
+	}
+	tests := []struct {
+		name        string
+		inputs      []input
+		matchers    []Matcher
+		multiline   bool
+		want        []string // "source:message", or "source:!raw line" when unparsed
+		wantInvalid int
+	}{
+		{
+			name: "interleaved",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 10:00:00 INFO a1\n2024-05-01 10:00:02 INFO a2\n2024-05-01 10:00:04 INFO a3\n"},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\n2024-05-01 10:00:03 INFO b2\n"},
+			},
+			want: []string{"a.log:a1", "b.log:b1", "a.log:a2", "b.log:b2", "a.log:a3"},
+		},
+		{
+			name: "equal times keep the input order",
+			inputs: []input{
+				{name: "b.log", text: "2024-05-01 10:00:00 INFO b1\n2024-05-01 10:00:00 INFO b2\n"},
+				{name: "a.log", text: "2024-05-01 10:00:00 INFO a1\n"},
+			},
+			want: []string{"b.log:b1", "b.log:b2", "a.log:a1"},
+		},
+		{
+			name: "a clock running behind",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 10:00:00 INFO a1\n2024-05-01 10:00:10 INFO a2\n"},
+				{name: "b.log", text: "2024-05-01 09:59:58 INFO b1\n", offset: 5 * time.Second},
+			},
+			want: []string{"a.log:a1", "b.log:b1", "a.log:a2"},
+		},
+		{
+			name: "a clock running ahead",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 10:00:00 INFO a1\n"},
+				{name: "b.log", text: "2024-05-01 10:00:30 INFO b1\n", offset: -time.Minute},
+			},
+			want: []string{"b.log:b1", "a.log:a1"},
+		},
+		{
+			name: "offsets apply before time filters",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 09:59:59 INFO a1\n2024-05-01 10:00:01 INFO a2\n"},
+				{name: "b.log", text: "2024-05-01 09:59:58 INFO b1\n", offset: 5 * time.Second},
+			},
+			matchers: []Matcher{TimeRange{Since: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}},
+			want:     []string{"a.log:a2", "b.log:b1"},
+		},
+		{
+			name: "unparsed lines stay behind their predecessor",
+			inputs: []input{
+				{name: "a.log", text: "garbage first\n2024-05-01 10:00:00 INFO a1\n2024-05-01 10:00:05 INFO a2\n"},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\ngarbage after b1\n2024-05-01 10:00:06 INFO b2\n"},
+			},
+			want:        []string{"a.log:!garbage first", "a.log:a1", "b.log:b1", "b.log:!garbage after b1", "a.log:a2", "b.log:b2"},
+			wantInvalid: 2,
+		},
+		{
+			name: "only kept records take part",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 10:00:00 ERROR a1\n2024-05-01 10:00:02 INFO a2\n2024-05-01 10:00:04 ERROR a3\n"},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\n2024-05-01 10:00:03 ERROR b2\n"},
+			},
+			matchers: []Matcher{LevelIs("ERROR")},
+			want:     []string{"a.log:a1", "b.log:b2", "a.log:a3"},
+		},
+		{
+			name: "empty inputs",
+			inputs: []input{
+				{name: "a.log", text: ""},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\n"},
+				{name: "c.log", text: ""},
+			},
+			want: []string{"b.log:b1"},
+		},
+		{
+			name: "formats are detected per input",
+			inputs: []input{
+				{name: "a.json", text: `{"time":"2024-05-01T10:00:02Z","level":"info","msg":"a1"}` + "\n"},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\n2024-05-01 10:00:03 INFO b2\n"},
+			},
+			want: []string{"b.log:b1", "a.json:a1", "b.log:b2"},
+		},
+		{
+			name: "multi-line records move as one",
+			inputs: []input{
+				{name: "a.log", text: "2024-05-01 10:00:00 ERROR a1\n\tat frame\n2024-05-01 10:00:02 INFO a2\n"},
+				{name: "b.log", text: "2024-05-01 10:00:01 INFO b1\n"},
+			},
+			multiline: true,
+			want:      []string{"a.log:a1\n\tat frame", "b.log:b1", "a.log:a2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var inputs []MergeInput
+			for _, in := range tt.inputs {
+				path := filepath.Join(dir, in.name)
+				if err := os.WriteFile(path, []byte(in.text), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				inputs = append(inputs, MergeInput{Name: path, Offset: in.offset})
+			}
+			sink := &recordSink{}
+			f := &Filter{Matchers: tt.matchers, Sink: sink, AutoDetect: true, Multiline: tt.multiline}
+			// Unparsed lines are listed where they come out, marked with "!".
+			f.OnInvalid = func(rec Record, _ error) {
+				sink.records = append(sink.records, Record{Source: rec.Source, Message: "!" + rec.Raw})
+			}
+			if err := f.RunMerged(inputs); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range sink.records {
+				got = append(got, filepath.Base(rec.Source)+":"+rec.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("merged = %q, want %q", got, tt.want)
+			}
+			if n := f.Invalid(); n != tt.wantInvalid {
+				t.Errorf("Invalid() = %d, want %d", n, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestRunMergedOffsetKeepsRawLine(t *testing.T) {
+	// The offset corrects the record time, but text output still prints the
+	// line as it was written.
+	path := filepath.Join(t.TempDir(), "b.log")
+	const line = "2024-05-01 09:59:58 INFO b1"
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	f := &Filter{Sink: sink}
+	if err := f.RunMerged([]MergeInput{{Name: path, Offset: 5 * time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(sink.records))
+	}
+	rec := sink.records[0]
+	if want := time.Date(2024, 5, 1, 10, 0, 3, 0, time.UTC); !rec.Time.Equal(want) {
+		t.Errorf("time = %v, want %v", rec.Time, want)
+	}
+	if rec.Raw != line {
+		t.Errorf("raw = %q, want %q", rec.Raw, line)
+	}
+}
+
+func TestRunMergedMissingInput(t *testing.T) {
+	f := &Filter{Sink: &recordSink{}}
+	err := f.RunMerged([]MergeInput{{Name: filepath.Join(t.TempDir(), "missing.log")}})
+	if err == nil || !strings.Contains(err.Error(), "missing.log") {
+		t.Errorf("RunMerged error = %v, want one naming the missing input", err)
+	}
+}
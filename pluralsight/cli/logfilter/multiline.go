@@ -0,0 +1,61 @@
+package logfilter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// LineGrouper joins continuation lines, such as the frames of a Go panic
+// or a Java stack trace, onto the record line that precedes them. Only the
+// first line of such a record carries a timestamp and level.
+type LineGrouper struct {
+	// IsStart reports whether line begins a new record. Every other line
+	// is a continuation of the current record.
+	IsStart func(line string) bool
+
+	// Emit receives each complete record, its lines joined with "\n".
+	Emit func(record string) error
+
+	pending []string
+}
+
+// RecordStartFunc returns the record-start test used for multi-line
+// records. With a nil pattern a line starts a record when it is not
+// indented and f can parse it, e.g. it begins with a timestamp and level.
+// Otherwise a line starts a record when it matches pattern.
+func RecordStartFunc(f Format, pattern *regexp.Regexp) func(line string) bool {
+	if pattern != nil {
+		return pattern.MatchString
+	}
+	return func(line string) bool {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			return false
+		}
+		_, err := f.Parse(line)
+		return err == nil
+	}
+}
+
+// Add feeds one line to the grouper. The previous record is emitted when
+// line starts a new one.
+func (g *LineGrouper) Add(line string) error {
+	if g.IsStart(line) && len(g.pending) > 0 {
+		if err := g.Flush(); err != nil {
+			return err
+		}
+	}
+	g.pending = append(g.pending, line)
+	return nil
+}
+
+// Flush emits the record collected so far, if any. It must be called at
+// the end of the input so the last record is not lost.
+func (g *LineGrouper) Flush() error {
+	if len(g.pending) == 0 {
+		return nil
+	}
+	record := strings.Join(g.pending, "\n")
+	// The slice is reused for the next record; Join already copied the text.
+	g.pending = g.pending[:0]
+	return g.Emit(record)
+}
@@ -0,0 +1,184 @@
+package logfilter
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineGrouper(t *testing.T) {
+	// In these tests a line starts a record when it begins with "#".
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"no lines", nil, nil},
+		{"single lines", []string{"#1", "#2"}, []string{"#1", "#2"}},
+		{"continuations", []string{"#1", " a", " b", "#2", " c"}, []string{"#1\n a\n b", "#2\n c"}},
+		// Lines before the first record start form a record of their own.
+		{"leading continuation", []string{" a", "b", "#1"}, []string{" a\nb", "#1"}},
+		{"empty lines continue", []string{"#1", "", "#2"}, []string{"#1\n", "#2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			g := &LineGrouper{
+				IsStart: func(line string) bool { return strings.HasPrefix(line, "#") },
+				Emit: func(record string) error {
+					got = append(got, record)
+					return nil
+				},
+			}
+			for _, line := range tt.lines {
+				if err := g.Add(line); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := g.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordStartFunc(t *testing.T) {
+	json, _ := LookupFormat("json")
+	tests := []struct {
+		name    string
+		format  Format
+		pattern *regexp.Regexp
+		line    string
+		want    bool
+	}{
+		{"plain record", Parser{}, nil, "2024-05-01 10:00:00 ERROR boom", true},
+		{"indented frame", Parser{}, nil, "\tmain.go:12 +0x1d", false},
+		{"space-indented frame", Parser{}, nil, "    at Main.run(Main.java:5)", false},
+		{"unparsable line", Parser{}, nil, "goroutine 1 [running]:", false},
+		{"empty line", Parser{}, nil, "", false},
+		{"json record", json.New(Parser{}), nil, `{"level":"error","msg":"boom"}`, true},
+		{"json continuation", json.New(Parser{}), nil, "Caused by: timeout", false},
+		// A pattern replaces the parser test, indentation included.
+		{"pattern match", Parser{}, regexp.MustCompile(`^\[`), "[main] started", true},
+		{"pattern ignores parser", Parser{}, regexp.MustCompile(`^\[`), "2024-05-01 10:00:00 ERROR boom", false},
+		{"pattern may match indented lines", Parser{}, regexp.MustCompile(`^\s*\[`), "  [worker] started", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RecordStartFunc(tt.format, tt.pattern)(tt.line); got != tt.want {
+				t.Errorf("IsStart(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMultiline(t *testing.T) {
+	const input = "" +
+		"goroutine 7 [running]:\n" +
+		"2024-05-01 10:00:00 INFO starting\n" +
+		"2024-05-01 10:00:01 ERROR request failed\n" +
+		"java.lang.IllegalStateException: closed\n" +
+		"    at Pool.get(Pool.java:42)\n" +
+		"\tat Server.handle(Server.java:7)\n" +
+		"2024-05-01 10:00:02 INFO retrying\n" +
+		"  attempt 2\n" +
+		"2024-05-01 10:00:03 ERROR gave up\n"
+	tests := []struct {
+		name        string
+		multiline   bool
+		start       *regexp.Regexp
+		matchers    []Matcher
+		want        []string // messages of the written records
+		wantInvalid int
+	}{
+		{
+			name:        "line by line",
+			matchers:    []Matcher{LevelIs("ERROR")},
+			want:        []string{"request failed", "gave up"},
+			wantInvalid: 5,
+		},
+		{
+			name:        "stack traces stay with their record",
+			multiline:   true,
+			matchers:    []Matcher{LevelIs("ERROR")},
+			want:        []string{"request failed\njava.lang.IllegalStateException: closed\n    at Pool.get(Pool.java:42)\n\tat Server.handle(Server.java:7)", "gave up"},
+			wantInvalid: 1, // the goroutine header has no record to join
+		},
+		{
+			name:        "continuation lines can be matched",
+			multiline:   true,
+			matchers:    []Matcher{mustQuery(t, "message contains Pool.java")},
+			want:        []string{"request failed\njava.lang.IllegalStateException: closed\n    at Pool.get(Pool.java:42)\n\tat Server.handle(Server.java:7)"},
+			wantInvalid: 1,
+		},
+		{
+			name:      "record start pattern",
+			multiline: true,
+			// Only the ERROR lines start records, so each swallows what follows.
+			start:       regexp.MustCompile(`^\S+ \S+ ERROR `),
+			matchers:    []Matcher{LevelIs("ERROR")},
+			want:        []string{"request failed\njava.lang.IllegalStateException: closed\n    at Pool.get(Pool.java:42)\n\tat Server.handle(Server.java:7)\n2024-05-01 10:00:02 INFO retrying\n  attempt 2", "gave up"},
+			wantInvalid: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordSink{}
+			f := &Filter{
+				Matchers:    tt.matchers,
+				Sink:        sink,
+				Multiline:   tt.multiline,
+				RecordStart: tt.start,
+			}
+			if err := f.Run(strings.NewReader(input), "app.log"); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range sink.records {
+				got = append(got, rec.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if n := f.Invalid(); n != tt.wantInvalid {
+				t.Errorf("Invalid() = %d, want %d", n, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestFilterMultilineRunFile(t *testing.T) {
+	// Large files are scanned in parallel chunks, which must not happen
+	// with Multiline: a chunk boundary could split a stack trace.
+	var b strings.Builder
+	for b.Len() < 4<<20 {
+		b.WriteString("2024-05-01 10:00:00 ERROR boom\n\tat frame one\n\tat frame two\n")
+	}
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	f := &Filter{Sink: sink, Multiline: true, Workers: 8, IgnoreIndex: true}
+	if err := f.RunFile(path); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Count(b.String(), "ERROR")
+	if len(sink.records) != want {
+		t.Fatalf("got %d records, want %d", len(sink.records), want)
+	}
+	for _, rec := range sink.records {
+		if rec.Message != "boom\n\tat frame one\n\tat frame two" {
+			t.Fatalf("record split: %q", rec.Message)
+		}
+	}
+	if n := f.Invalid(); n != 0 {
+		t.Errorf("Invalid() = %d, want 0", n)
+	}
+}
@@ -0,0 +1,330 @@
+package logfilter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Flusher is implemented by sinks that buffer their output. Filter flushes
+// them after every record while following files.
+type Flusher interface {
+	Flush() error
+}
+
+// OutputFormats lists the formats accepted by NewSink.
+var OutputFormats = []string{"text", "json", "ndjson", "csv"}
+
+// SinkOptions adjusts the output of NewSink.
+type SinkOptions struct {
+	// WithSource only affects the text format, where it prefixes lines
+	// with their source like grep -H; structured formats always include
+	// the source.
+	WithSource bool
+
+	// Fields, if set, selects the printed columns by name (see
+	// Record.Field) instead of the whole record: tab-separated values in
+	// text, objects with just these keys in JSON, and these columns in CSV.
+	Fields []string
+
+	// Color formats text output for a terminal: records are printed as
+	// aligned time, level and message columns with the level coloured by
+	// severity, and matches of Highlight are marked. Lines that could not
+	// be parsed, such as context lines, are printed dimmed as they are.
+	Color bool
+
+	// Highlight, if set, marks the text that matched in colour output
+	// (see QueryHighlight).
+	Highlight *regexp.Regexp
+
+	// TimeLayout is the layout of the time column in colour output. It
+	// defaults to DefaultTimeLayout.
+	TimeLayout string
+}
+
+// NewSink returns a Sink printing records in the named format to w. The
+// returned sink also implements Flusher.
+func NewSink(format string, w io.Writer, opts SinkOptions) (Sink, error) {
+	// Output is buffered so a large result set does not cost one write(2)
+	// per record; Close flushes it.
+	bw := bufio.NewWriter(w)
+	switch strings.ToLower(format) {
+	case "text", "":
+		t := &textWriter{w: bw, withSource: opts.WithSource, fields: opts.Fields}
+		if opts.Color {
+			t.color, t.highlight, t.timeLayout = true, opts.Highlight, opts.TimeLayout
+			if t.timeLayout == "" {
+				t.timeLayout = DefaultTimeLayout
+			}
+		}
+		return t, nil
+	case "json":
+		return &jsonWriter{w: bw, array: true, fields: opts.Fields}, nil
+	case "ndjson", "jsonl":
+		return &jsonWriter{w: bw, fields: opts.Fields}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(bw), bw: bw, fields: opts.Fields}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want one of %s)", format, strings.Join(OutputFormats, ", "))
+}
+
+// jsonRecord is the structured form of a Record used by the JSON, NDJSON
+// and CSV writers.
+type jsonRecord struct {
+	Time    string            `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Source  string            `json:"source,omitempty"`
+	Context bool              `json:"context,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func toJSONRecord(rec Record) jsonRecord {
+	return jsonRecord{
+		// RFC 3339 keeps the offset, so downstream tools see the real instant.
+		Time:    rec.Time.Format(time.RFC3339Nano),
+		Level:   rec.Level,
+		Message: rec.Message,
+		Source:  rec.Source,
+		Context: rec.Context,
+		Fields:  rec.Fields,
+	}
+}
+
+// missingField is printed in text output for a selected field the record
+// does not have.
+const missingField = "-"
+
+// fieldValues returns the values of the selected fields of rec.
+func fieldValues(rec Record, fields []string, missing string) []string {
+	values := make([]string, len(fields))
+	for i, name := range fields {
+		v, ok := rec.Field(name)
+		if !ok {
+			v = missing
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// textWriter prints the original line, as the tool always has, or the
+// selected fields separated by tabs. With color set it prints aligned,
+// coloured columns instead (see SinkOptions.Color).
+type textWriter struct {
+	w          *bufio.Writer
+	withSource bool
+	fields     []string
+
+	color      bool
+	highlight  *regexp.Regexp
+	timeLayout string
+}
+
+func (t *textWriter) Write(rec Record) error {
+	var line string
+	switch {
+	case t.fields != nil:
+		values := fieldValues(rec, t.fields, missingField)
+		if t.color {
+			t.colorFields(rec, values)
+		}
+		line = strings.Join(values, "\t")
+	case t.color:
+		line = t.colorLine(rec)
+	default:
+		line = rec.Raw
+	}
+	if t.withSource {
+		// Like grep, context lines use "-" after the file name instead of ":".
+		sep := ":"
+		if rec.Context {
+			sep = "-"
+		}
+		source := rec.Source
+		if t.color {
+			source = colorize(source, sourceColor)
+		}
+		_, err := fmt.Fprintf(t.w, "%s%s%s\n", source, sep, line)
+		return err
+	}
+	_, err := fmt.Fprintln(t.w, line)
+	return err
+}
+
+// colorLine formats rec as aligned time, level and message columns.
+// Context lines are dimmed so the matches stand out.
+func (t *textWriter) colorLine(rec Record) string {
+	if rec.Level == "" {
+		return colorize(rec.Raw, ansiDim)
+	}
+	ts := rec.Time.Format(t.timeLayout)
+	if rec.Context {
+		return colorize(fmt.Sprintf("%s %-*s %s", ts, levelWidth, rec.Level, rec.Message), ansiDim)
+	}
+	return colorize(ts, ansiDim) + " " + paddedLevel(rec.Level) + " " + highlight(rec.Message, t.highlight)
+}
+
+// colorFields colours the level and highlights the message and raw
+// values among the selected fields.
+func (t *textWriter) colorFields(rec Record, values []string) {
+	if rec.Context {
+		for i, v := range values {
+			values[i] = colorize(v, ansiDim)
+		}
+		return
+	}
+	for i, name := range t.fields {
+		switch name {
+		case "level":
+			values[i] = colorize(values[i], levelColor(values[i]))
+		case "message", "msg", "raw", "line":
+			values[i] = highlight(values[i], t.highlight)
+		}
+	}
+}
+
+// WriteSeparator implements Separator with grep's "--" line.
+func (t *textWriter) WriteSeparator() error {
+	sep := "--"
+	if t.color {
+		sep = colorize(sep, separatorColor)
+	}
+	_, err := t.w.WriteString(sep + "\n")
+	return err
+}
+
+func (t *textWriter) Flush() error { return t.w.Flush() }
+func (t *textWriter) Close() error { return t.w.Flush() }
+
+// jsonWriter prints one JSON object per line (NDJSON), or a single JSON
+// array when array is true.
+type jsonWriter struct {
+	w      *bufio.Writer
+	array  bool
+	count  int
+	fields []string
+}
+
+func (j *jsonWriter) Write(rec Record) error {
+	var data []byte
+	var err error
+	if j.fields != nil {
+		data, err = selectedJSON(rec, j.fields)
+	} else {
+		data, err = json.Marshal(toJSONRecord(rec))
+	}
+	if err != nil {
+		return err
+	}
+	if j.array {
+		sep := ",\n  "
+		if j.count == 0 {
+			sep = "[\n  "
+		}
+		j.w.WriteString(sep)
+	}
+	j.count++
+	j.w.Write(data)
+	if !j.array {
+		j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// selectedJSON encodes the selected fields of rec as an object whose keys
+// keep the order they were selected in. Missing fields are null.
+func selectedJSON(rec Record, fields []string) ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		v, ok := rec.Field(name)
+		if !ok {
+			b.WriteString("null")
+			continue
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+func (j *jsonWriter) Flush() error { return j.w.Flush() }
+
+func (j *jsonWriter) Close() error {
+	if j.array {
+		// An empty result is still a valid JSON document.
+		if j.count == 0 {
+			j.w.WriteString("[]\n")
+		} else {
+			j.w.WriteString("\n]\n")
+		}
+	}
+	return j.w.Flush()
+}
+
+// csvWriter prints a header row followed by one row per record. The
+// columns are the selected fields, if any.
+type csvWriter struct {
+	w      *csv.Writer
+	bw     *bufio.Writer
+	header bool
+	fields []string
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	if c.fields != nil {
+		return c.w.Write(c.fields)
+	}
+	return c.w.Write([]string{"time", "level", "message", "source"})
+}
+
+func (c *csvWriter) Write(rec Record) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	if c.fields != nil {
+		return c.w.Write(fieldValues(rec, c.fields, ""))
+	}
+	j := toJSONRecord(rec)
+	return c.w.Write([]string{j.Time, j.Level, j.Message, j.Source})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// Close writes the header even when nothing matched, so the output is
+// always a well-formed CSV file.
+func (c *csvWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.Flush()
+}
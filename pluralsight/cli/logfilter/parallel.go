@@ -0,0 +1,152 @@
+package logfilter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// DefaultChunkSize is the size of the byte ranges handed to each worker by
+// ScanParallel. Files smaller than one chunk are not worth splitting.
+const DefaultChunkSize = 4 << 20 // 4 MiB
+
+// chunk is a line-aligned byte range [start, end) of the input.
+type chunk struct {
+	index      int
+	start, end int64
+}
+
+// chunkResult holds the kept values of one chunk, in line order.
+type chunkResult[T any] struct {
+	index int
+	items []T
+	err   error
+}
+
+// ScanParallel splits the first size bytes of r into line-aligned chunks of
+// roughly chunkSize bytes and scans them on a pool of workers. eval is called
+// for every line (concurrently, so it must not touch shared state) and
+// returns the value to keep and whether to keep it. emit receives the kept
+// values on the calling goroutine in the original line order, so the output
+// is identical to a sequential scan. The scan stops at the first error
+// returned by emit.
+//
+// At most 2*workers chunks are in flight at any time, which bounds memory
+// use regardless of file size.
+func ScanParallel[T any](r io.ReaderAt, size int64, workers int, chunkSize int64, eval func(line string) (T, bool), emit func(T) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	jobs := make(chan chunk)
+	results := make(chan chunkResult[T])
+	// slots limits the number of chunks that have been handed out but not yet
+	// emitted; a slot is released once its chunk has been written.
+	slots := make(chan struct{}, 2*workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Producer: find the chunk boundaries and hand them out in order.
+	var splitErr error
+	go func() {
+		defer close(jobs)
+		start := int64(0)
+		for index := 0; start < size; index++ {
+			end, err := nextLineStart(r, start+chunkSize, size)
+			if err != nil {
+				splitErr = err
+				end = size
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- chunk{index: index, start: start, end: end}:
+			case <-done:
+				return
+			}
+			if splitErr != nil {
+				return
+			}
+			start = end
+		}
+	}()
+
+	// Workers: scan their chunk and collect the kept values.
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				res := chunkResult[T]{index: c.index}
+				res.err = ScanLines(io.NewSectionReader(r, c.start, c.end-c.start), func(line string) error {
+					if v, keep := eval(line); keep {
+						res.items = append(res.items, v)
+					}
+					return nil
+				})
+				select {
+				case results <- res:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Reassembly: chunks can finish in any order, so results are parked until
+	// every earlier chunk has been emitted.
+	pending := map[int]chunkResult[T]{}
+	next := 0
+	for res := range results {
+		pending[res.index] = res
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if ready.err != nil {
+				return ready.err
+			}
+			for _, v := range ready.items {
+				if err := emit(v); err != nil {
+					return err
+				}
+			}
+			next++
+			<-slots
+		}
+	}
+	return splitErr
+}
+
+// nextLineStart returns the offset of the first byte after the first newline
+// at or after off, or size if there is none.
+func nextLineStart(r io.ReaderAt, off, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for off < size {
+		n, err := r.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i) + 1, nil
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,169 @@
+package logfilter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBigLog writes a log.txt-style file of at least size bytes with a
+// mix of levels and returns its path.
+func writeBigLog(tb testing.TB, size int) string {
+	tb.Helper()
+	levels := []string{"DEBUG", "INFO", "INFO", "WARNING", "ERROR", "CRITICAL"}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "%s %s request %d from user %d took %dms\n",
+			start.Add(time.Duration(i)*time.Second).Format(DefaultTimeLayout), levels[i%len(levels)], i, i%977, i%503)
+	}
+	path := filepath.Join(tb.TempDir(), "big.log")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// scanSequential is the sequential counterpart of ScanParallel.
+func scanSequential(path string, eval func(string) (string, bool), emit func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ScanLines(f, func(line string) error {
+		if v, keep := eval(line); keep {
+			return emit(v)
+		}
+		return nil
+	})
+}
+
+func scanParallel(path string, workers int, chunkSize int64, eval func(string) (string, bool), emit func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return ScanParallel(f, info.Size(), workers, chunkSize, eval, emit)
+}
+
+// evalWarning parses a line and keeps it when it is WARNING or above,
+// which is the work a typical -min-level run does per line.
+func evalWarning(line string) (string, bool) {
+	rec, err := Parser{}.Parse(line)
+	if err != nil {
+		return "", false
+	}
+	sev, err := ParseSeverity(rec.Level)
+	return line, err == nil && sev >= SeverityWarning
+}
+
+func TestScanParallelMatchesSequential(t *testing.T) {
+	path := writeBigLog(t, 3*DefaultChunkSize+12345)
+
+	var want []string
+	if err := scanSequential(path, evalWarning, func(s string) error { want = append(want, s); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 {
+		t.Fatal("sequential scan kept nothing")
+	}
+
+	tests := []struct {
+		workers   int
+		chunkSize int64
+	}{
+		{1, DefaultChunkSize},
+		{4, DefaultChunkSize},
+		{8, 1 << 20},
+		{3, 4097}, // chunk boundaries fall inside lines
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("workers=%d/chunk=%d", tt.workers, tt.chunkSize), func(t *testing.T) {
+			var got []string
+			err := scanParallel(path, tt.workers, tt.chunkSize, evalWarning, func(s string) error { got = append(got, s); return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("parallel scan kept %d lines, sequential %d; outputs differ", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestRunFileParallelMatchesSequential(t *testing.T) {
+	path := writeBigLog(t, 2*DefaultChunkSize+777)
+	// Workers are capped at GOMAXPROCS; raise it so the parallel path runs
+	// on small machines too.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	run := func(workers int) []Record {
+		sink := &recordSink{}
+		f := &Filter{
+			Matchers:    []Matcher{LevelRange{Min: SeverityError, Max: SeverityEmergency}},
+			Sink:        sink,
+			Workers:     workers,
+			IgnoreIndex: true,
+		}
+		if err := f.RunFile(path); err != nil {
+			t.Fatal(err)
+		}
+		return sink.records
+	}
+	want, got := run(1), run(8)
+	if len(want) == 0 || !slices.EqualFunc(got, want, func(a, b Record) bool { return a.Raw == b.Raw }) {
+		t.Errorf("-workers 8 wrote %d records, -workers 1 wrote %d; outputs differ", len(got), len(want))
+	}
+}
+
+func BenchmarkScanSequential(b *testing.B) {
+	path := writeBigLog(b, 4*DefaultChunkSize)
+	info, _ := os.Stat(path)
+	b.SetBytes(info.Size())
+	for b.Loop() {
+		if err := scanSequential(path, evalWarning, func(string) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkScanParallel runs ScanParallel over the same file with several
+// worker counts and chunk sizes, to show where splitting starts to pay off.
+func BenchmarkScanParallel(b *testing.B) {
+	path := writeBigLog(b, 4*DefaultChunkSize)
+	info, _ := os.Stat(path)
+	for _, workers := range []int{1, 2, 4, 8} {
+		for _, chunkSize := range []int64{256 << 10, 1 << 20, DefaultChunkSize} {
+			b.Run(fmt.Sprintf("workers=%d/chunk=%dK", workers, chunkSize>>10), func(b *testing.B) {
+				b.SetBytes(info.Size())
+				for b.Loop() {
+					if err := scanParallel(path, workers, chunkSize, evalWarning, func(string) error { return nil }); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// recordSink collects the records written to it.
+type recordSink struct {
+	records []Record
+}
+
+func (s *recordSink) Write(rec Record) error {
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *recordSink) Close() error { return nil }
@@ -0,0 +1,143 @@
+package logfilter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultTimeLayout is the timestamp layout used by log.txt, written in Go's
+// reference-time notation (Mon Jan 2 15:04:05 MST 2006).
+const DefaultTimeLayout = "2006-01-02 15:04:05"
+
+// Record is a single parsed log line of the form
+// "YYYY-MM-DD HH:MM:SS LEVEL message".
+type Record struct {
+	Time    time.Time // Parsed timestamp
+	Level   string    // Severity, e.g. "CRITICAL"
+	Message string    // Everything after the level
+	Raw     string    // The original line without its trailing newline
+	Source  string    // Name of the input the line was read from, if known
+	Context bool      // Printed only as context around a match (-A, -B, -C)
+
+	// Fields holds named values beyond the ones above: the extra keys of
+	// JSON and logfmt lines and anything added by an Extractor. It is nil
+	// when there are none.
+	Fields map[string]string
+}
+
+// ErrMalformed is wrapped by every error returned from Parser.Parse, so
+// callers can check for it with errors.Is.
+var ErrMalformed = errors.New("malformed log line")
+
+// Parser parses lines whose timestamp is written in Layout. The zero value
+// parses log.txt: DefaultTimeLayout in UTC.
+type Parser struct {
+	// Layout is the timestamp format in Go reference-time notation.
+	// An empty Layout means DefaultTimeLayout.
+	Layout string
+
+	// Location is the time zone assumed for timestamps that do not carry
+	// their own offset. A nil Location means UTC.
+	Location *time.Location
+}
+
+// layout returns the effective timestamp layout.
+func (p Parser) layout() string {
+	if p.Layout == "" {
+		return DefaultTimeLayout
+	}
+	return p.Layout
+}
+
+// location returns the effective time zone.
+func (p Parser) location() *time.Location {
+	if p.Location == nil {
+		return time.UTC
+	}
+	return p.Location
+}
+
+// ParseLine parses a line with the default Parser.
+func ParseLine(line string) (Record, error) {
+	return Parser{}.Parse(line)
+}
+
+// Parse splits a raw line into its timestamp, level and message fields.
+// The timestamp is made of the first whitespace-separated fields of the line
+// (as many as the layout has), the level is the next field and the rest of
+// the line is the message. Short level spellings are canonicalized, so a
+// WARN line has the level WARNING. Parser is the "plain" Format.
+func (p Parser) Parse(line string) (Record, error) {
+	raw := strings.TrimRight(line, "\r\n")
+	rec := Record{Raw: raw}
+
+	layout, loc := p.layout(), p.location()
+
+	// strings.Fields would collapse repeated spaces inside the message, so only
+	// the byte offsets of the leading fields are located and the text between
+	// them is kept as-is. Keeping the original spacing of the timestamp also lets
+	// padded layouts such as time.Stamp ("Jan _2 15:04:05") parse correctly.
+	tsFields := len(strings.Fields(layout))
+	tsEnd, ok := fieldEnd(raw, 0, tsFields)
+	if !ok {
+		return rec, fmt.Errorf("%w: expected timestamp and level", ErrMalformed)
+	}
+	levelEnd, ok := fieldEnd(raw, tsEnd, 1)
+	if !ok {
+		return rec, fmt.Errorf("%w: expected timestamp and level", ErrMalformed)
+	}
+
+	ts, err := time.ParseInLocation(layout, raw[:tsEnd], loc)
+	if err != nil {
+		return rec, fmt.Errorf("%w: bad timestamp: %v", ErrMalformed, err)
+	}
+	rec.Time = ts
+
+	level := strings.TrimLeft(raw[tsEnd:levelEnd], " \t")
+	if !isLevelWord(level) {
+		return rec, fmt.Errorf("%w: bad level %q", ErrMalformed, level)
+	}
+	rec.Level = canonicalLevel(level)
+
+	// A single separator after the level is dropped; the message keeps the rest.
+	rec.Message = raw[levelEnd:]
+	if rec.Message != "" {
+		rec.Message = rec.Message[1:]
+	}
+	return rec, nil
+}
+
+// fieldEnd skips n whitespace-separated fields of s starting at byte offset
+// start and returns the offset just past the last one. ok is false when s
+// has fewer than n fields left.
+func fieldEnd(s string, start, n int) (end int, ok bool) {
+	i := start
+	for ; n > 0; n-- {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i == len(s) {
+			return i, false
+		}
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+	}
+	return i, true
+}
+
+// isLevelWord reports whether s looks like a severity: one or more
+// upper-case ASCII letters.
+func isLevelWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
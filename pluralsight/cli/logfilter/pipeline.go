@@ -0,0 +1,448 @@
+package logfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Options describes a complete filtering run the way the command line
+// does: every field holds the raw value of the flag of the same name, and
+// NewPipeline checks them and builds the Filter and its sinks. Error
+// messages therefore name the flags.
+type Options struct {
+	// Parsing
+	TimeLayout  string // a Go layout or a name such as rfc3339 (see ResolveLayout)
+	TimeZone    string // a time zone name; "" means UTC
+	Format      string // an input format name (see ResolveFormat)
+	ShowInvalid bool   // report lines that cannot be parsed on Stderr
+	Multiline   bool
+	RecordStart string // a regular expression; implies Multiline
+	Extractors  []Extractor
+	KeyValues   bool
+
+	// Filtering. Level is compared exactly unless MinLevel or MaxLevel
+	// selects a range. Query, the summary modes, alerts and AllLevels make
+	// Level unnecessary, so it is then only used when LevelSet says it was
+	// given explicitly.
+	Level, MinLevel, MaxLevel string
+	LevelSet                  bool
+	AllLevels                 bool
+	Since, Until              string
+	Query                     string
+
+	// Now is the reference for relative times in Since, Until and Query.
+	// The zero value means time.Now.
+	Now time.Time
+
+	// Reading
+	Workers       int
+	IgnoreIndex   bool
+	Merge         bool
+	Offsets       map[string]time.Duration // clock corrections per input, with Merge
+	Before, After int
+	Follow        bool
+	Poll          time.Duration
+
+	// Output
+	Output         string // text, json, ndjson or csv
+	Fields         string // a comma-separated field list
+	WithSource     bool
+	Color          string // a colour mode (see ColorEnabled)
+	Dedupe         bool
+	DedupeWindow   time.Duration // implies Dedupe
+	Redact         bool
+	RedactPatterns []string // imply Redact
+	RedactHash     bool     // implies Redact
+	RedactKey      string
+
+	// Summaries, alerts and Kafka replace the printed records.
+	Stats, Cluster bool
+	Bucket         string
+	Top            int
+	Alerts         string // a rules file (see LoadRules)
+	KafkaTopic     string
+	KafkaBatch     int
+
+	// NewProducer connects to Kafka when KafkaTopic is set.
+	NewProducer func() (Producer, error)
+
+	// Stdout receives the records and reports, Stderr diagnostics such as
+	// invalid lines and failed alert actions. They default to os.Stdout
+	// and os.Stderr.
+	Stdout, Stderr io.Writer
+}
+
+// Parsing returns the Parser and Format described by the parsing options.
+// A nil Format (for "auto") makes a Filter detect the format of each input.
+func (o *Options) Parsing() (Parser, Format, error) {
+	zone := o.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	// time.LoadLocation understands IANA names as well as "UTC" and "Local".
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return Parser{}, nil, fmt.Errorf("-tz: %w", err)
+	}
+	parser := Parser{Layout: ResolveLayout(o.TimeLayout), Location: loc}
+	format, err := ResolveFormat(o.Format, parser)
+	if err != nil {
+		return Parser{}, nil, fmt.Errorf("-format: %w", err)
+	}
+	return parser, format, nil
+}
+
+// RecordStartPattern compiles RecordStart, or returns nil when it is unset.
+func (o *Options) RecordStartPattern() (*regexp.Regexp, error) {
+	if o.RecordStart == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(o.RecordStart)
+	if err != nil {
+		return nil, fmt.Errorf("-record-start: %w", err)
+	}
+	return re, nil
+}
+
+// Pipeline is a Filter wired to the sinks and reports chosen by Options.
+type Pipeline struct {
+	Filter *Filter
+
+	opts     Options
+	sink     Sink
+	stats    *Stats
+	clusters *Clusterer
+	kafka    *KafkaSink
+}
+
+// NewPipeline checks o and builds the Filter, its matchers and its sinks.
+// Nothing is read yet, so an invalid option fails before any input is
+// opened.
+func NewPipeline(o Options) (*Pipeline, error) {
+	if o.Stdout == nil {
+		o.Stdout = os.Stdout
+	}
+	if o.Stderr == nil {
+		o.Stderr = os.Stderr
+	}
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	parser, format, err := o.Parsing()
+	if err != nil {
+		return nil, err
+	}
+	matchers, highlight, err := o.matchers(parser)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{opts: o}
+	if err := p.newSink(parser, highlight); err != nil {
+		return nil, err
+	}
+
+	startPattern, err := o.RecordStartPattern()
+	if err != nil {
+		return nil, err
+	}
+	extractors := o.Extractors
+	if o.KeyValues {
+		extractors = append(slices.Clip(extractors), KeyValueExtractor{})
+	}
+	p.Filter = &Filter{
+		Parser:      parser,
+		Format:      format,
+		AutoDetect:  format == nil,
+		Extractors:  extractors,
+		Matchers:    matchers,
+		Sink:        p.sink,
+		Workers:     o.Workers,
+		IgnoreIndex: o.IgnoreIndex,
+		Before:      o.Before,
+		After:       o.After,
+		Multiline:   o.Multiline || startPattern != nil,
+		RecordStart: startPattern,
+	}
+	// Lines that do not follow the log format never match; they are counted
+	// and, if requested, reported.
+	if o.ShowInvalid {
+		p.Filter.OnInvalid = func(rec Record, err error) {
+			fmt.Fprintf(o.Stderr, "invalid line: %s: %v: %s\n", rec.Source, err, rec.Raw)
+		}
+	}
+	return p, nil
+}
+
+// check rejects combinations of options that make no sense together.
+func (o *Options) check() error {
+	// Summary modes replace the per-line output, so only one can be active.
+	summary := o.Stats || o.Cluster
+	switch {
+	case o.Stats && o.Cluster:
+		return errors.New("-stats and -cluster cannot be combined")
+	case summary && o.Output == "csv":
+		return errors.New("-stats and -cluster do not support -output csv")
+	case summary && o.Fields != "":
+		return errors.New("-fields cannot be combined with -stats or -cluster")
+	case o.Alerts != "" && summary:
+		return errors.New("-alerts cannot be combined with -stats or -cluster")
+	case o.Before < 0 || o.After < 0:
+		return errors.New("context line counts cannot be negative")
+	case summary && (o.Before > 0 || o.After > 0):
+		return errors.New("-A, -B and -C cannot be combined with -stats or -cluster")
+	case (o.Dedupe || o.DedupeWindow > 0) && (summary || o.Alerts != ""):
+		return errors.New("-dedupe cannot be combined with -stats, -cluster or -alerts")
+	case o.KafkaTopic != "" && (summary || o.Alerts != ""):
+		return errors.New("-kafka-topic cannot be combined with -stats, -cluster or -alerts")
+	case o.DedupeWindow < 0:
+		return errors.New("-dedupe-window cannot be negative")
+	case o.Merge && (o.Follow || o.Before > 0 || o.After > 0):
+		return errors.New("-merge cannot be combined with following or with -A, -B and -C")
+	case len(o.Offsets) > 0 && !o.Merge:
+		return errors.New("-offset requires -merge")
+	}
+	return nil
+}
+
+// matchers turns the filtering options into Matchers; a record is kept
+// only when all of them accept it. It also returns what the query searches
+// the messages for, to highlight in colour output.
+func (o *Options) matchers(parser Parser) ([]Matcher, *regexp.Regexp, error) {
+	var matchers []Matcher
+
+	// By default the level is compared exactly against Level; a range
+	// replaces it with a severity comparison. A query is a complete filter
+	// on its own, the summaries cover every level, alert rules bring their
+	// own queries and AllLevels asks for every record, so in these cases
+	// the default level is dropped unless it was set explicitly.
+	switch {
+	case o.MinLevel != "" || o.MaxLevel != "":
+		if o.LevelSet {
+			return nil, nil, errors.New("-level cannot be combined with -min-level or -max-level")
+		}
+		levels, err := ParseLevelRange(o.MinLevel, o.MaxLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		matchers = append(matchers, levels)
+	case (!o.AllLevels && o.Query == "" && !o.Stats && !o.Cluster && o.Alerts == "") || o.LevelSet:
+		// Parsed records carry canonical level names, so -level WARN has
+		// to select the WARNING records.
+		level := o.Level
+		if s, ok := severityAliases[level]; ok {
+			level = s.String()
+		}
+		matchers = append(matchers, LevelIs(level))
+	}
+
+	now := o.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var timeRange TimeRange
+	var err error
+	if o.Since != "" {
+		if timeRange.Since, err = ParseTimeBound(o.Since, parser.Layout, parser.Location, now); err != nil {
+			return nil, nil, fmt.Errorf("-since: %w", err)
+		}
+	}
+	if o.Until != "" {
+		if timeRange.Until, err = ParseTimeBound(o.Until, parser.Layout, parser.Location, now); err != nil {
+			return nil, nil, fmt.Errorf("-until: %w", err)
+		}
+	}
+	if !timeRange.IsZero() {
+		matchers = append(matchers, timeRange)
+	}
+
+	// The query is compiled once up front; syntax errors point at the
+	// offending column.
+	var highlight *regexp.Regexp
+	if o.Query != "" {
+		query, err := ParseQuery(o.Query, parser, now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w\n%s", err, QueryErrorContext(o.Query, err))
+		}
+		matchers = append(matchers, query)
+		highlight = QueryHighlight(query)
+	}
+	return matchers, highlight, nil
+}
+
+// newSink creates the sink receiving every matching record: a summary in
+// the stats and cluster modes, the alert rules, Kafka, or else a writer
+// for Output.
+func (p *Pipeline) newSink(parser Parser, highlight *regexp.Regexp) error {
+	o := &p.opts
+	var redactor *Redactor
+	if o.Redact || len(o.RedactPatterns) > 0 || o.RedactHash {
+		var err error
+		if redactor, err = NewRedactor(o.RedactPatterns); err != nil {
+			return err
+		}
+		redactor.Hash, redactor.Key = o.RedactHash, o.RedactKey
+	}
+	switch {
+	case o.Stats:
+		width, err := ParseBucket(o.Bucket)
+		if err != nil {
+			return fmt.Errorf("-bucket: %w", err)
+		}
+		p.stats = NewStats(width)
+		p.sink = p.stats
+	case o.Cluster:
+		p.clusters = NewClusterer()
+		p.sink = p.clusters
+	case o.Alerts != "":
+		rules, err := LoadRules(o.Alerts, parser)
+		if err != nil {
+			return err
+		}
+		// A failing action is reported but does not stop the watch.
+		p.sink = &Alerter{Rules: rules, Redactor: redactor, OnError: func(rule string, err error) {
+			fmt.Fprintf(o.Stderr, "alert %s: %v\n", rule, err)
+		}}
+	case o.KafkaTopic != "":
+		if o.NewProducer == nil {
+			return errors.New("-kafka-topic: no Kafka client is available")
+		}
+		producer, err := o.NewProducer()
+		if err != nil {
+			return err
+		}
+		// Failed deliveries are reported as they come in; Close fails if
+		// there were any.
+		p.kafka = &KafkaSink{
+			Producer:  producer,
+			Topic:     o.KafkaTopic,
+			Fields:    ParseFieldList(o.Fields),
+			BatchSize: o.KafkaBatch,
+			OnError: func(err *DeliveryError) {
+				fmt.Fprintf(o.Stderr, "kafka: %v\n", err)
+			},
+		}
+		p.sink = p.kafka
+	default:
+		color, err := ColorEnabled(o.Color, o.Stdout)
+		if err != nil {
+			return fmt.Errorf("-color: %w", err)
+		}
+		opts := SinkOptions{
+			WithSource: o.WithSource,
+			Fields:     ParseFieldList(o.Fields),
+			Color:      color,
+			Highlight:  highlight,
+			TimeLayout: parser.Layout,
+		}
+		if p.sink, err = NewSink(o.Output, o.Stdout, opts); err != nil {
+			return err
+		}
+	}
+	// Redaction wraps whichever sink was chosen, so summaries are masked as
+	// well. Alert rules have to see the original text, so the Alerter only
+	// masks the records it sends.
+	if redactor != nil && o.Alerts == "" {
+		p.sink = &RedactSink{Sink: p.sink, Redactor: redactor}
+	}
+
+	// Repeats are collapsed before printing or publishing. Dedupe sits
+	// outside the redaction, so it compares the original messages: two
+	// users' e-mail addresses masked to the same placeholder are no repeat.
+	if o.Dedupe || o.DedupeWindow > 0 {
+		p.sink = &DedupeSink{Sink: p.sink, Window: o.DedupeWindow}
+	}
+	return nil
+}
+
+// Run reads the named inputs: all at once until ctx is cancelled when
+// following, merged by time with Merge, and one after the other otherwise.
+func (p *Pipeline) Run(ctx context.Context, inputs []string) error {
+	o := &p.opts
+	switch {
+	case o.Follow:
+		// A follower that fails is reported; the others keep going.
+		var wg sync.WaitGroup
+		for _, name := range inputs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := p.Filter.Follow(ctx, name, o.Poll); err != nil {
+					fmt.Fprintf(o.Stderr, "%s: %v\n", DisplayName(name), err)
+				}
+			}()
+		}
+		wg.Wait()
+	case o.Merge:
+		// Each record goes out only when no other input has an earlier one left.
+		for name := range o.Offsets {
+			if !slices.Contains(inputs, name) {
+				return fmt.Errorf("-offset: %s is not an input", name)
+			}
+		}
+		merged := make([]MergeInput, len(inputs))
+		for i, name := range inputs {
+			merged[i] = MergeInput{Name: name, Offset: o.Offsets[name]}
+		}
+		return p.Filter.RunMerged(merged)
+	default:
+		for _, name := range inputs {
+			if err := p.Filter.RunFile(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close completes the output, e.g. the closing bracket of a JSON array,
+// and prints the report of the summary modes, which is then the only
+// output. It fails when records could not be delivered to Kafka, so a cron
+// job notices.
+func (p *Pipeline) Close() error {
+	o := &p.opts
+	if err := p.sink.Close(); err != nil {
+		return err
+	}
+
+	// JSON formats get a JSON report.
+	jsonReport := o.Output == "json" || o.Output == "ndjson"
+	var err error
+	switch {
+	case p.stats != nil:
+		p.stats.Invalid = p.Filter.Invalid()
+		if jsonReport {
+			err = p.stats.WriteJSON(o.Stdout, o.Top)
+		} else {
+			err = p.stats.WriteText(o.Stdout, o.Top)
+		}
+	case p.clusters != nil:
+		if jsonReport {
+			err = p.clusters.WriteJSON(o.Stdout)
+		} else {
+			err = p.clusters.WriteText(o.Stdout)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The stats report counts unparsable lines itself.
+	if n := p.Filter.Invalid(); n > 0 && p.stats == nil {
+		fmt.Fprintf(o.Stderr, "%d line(s) could not be parsed\n", n)
+	}
+	if p.kafka != nil && p.kafka.Failed() > 0 {
+		return fmt.Errorf("%d of %d record(s) could not be delivered to Kafka", p.kafka.Failed(), p.kafka.Written())
+	}
+	return nil
+}
+
+// Matched returns the number of records that matched, for the exit status.
+func (p *Pipeline) Matched() int { return p.Filter.Matched() }
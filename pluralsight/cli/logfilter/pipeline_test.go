@@ -0,0 +1,221 @@
+package logfilter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runPipeline runs o over ../log.txt and returns what it printed.
+func runPipeline(t *testing.T, o Options) (stdout, stderr string, matched int) {
+	t.Helper()
+	var out, errs bytes.Buffer
+	o.Stdout, o.Stderr = &out, &errs
+	p, err := NewPipeline(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background(), []string{"../log.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errs.String(), p.Matched()
+}
+
+func TestNewPipelineRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"stats and cluster", Options{Stats: true, Cluster: true}, "-stats and -cluster cannot be combined"},
+		{"stats as csv", Options{Stats: true, Output: "csv"}, "do not support -output csv"},
+		{"cluster with fields", Options{Cluster: true, Fields: "level"}, "-fields cannot be combined"},
+		{"alerts with stats", Options{Alerts: "rules.yaml", Stats: true}, "-alerts cannot be combined"},
+		{"negative context", Options{Before: -1}, "cannot be negative"},
+		{"context with stats", Options{Stats: true, After: 2}, "-A, -B and -C cannot be combined"},
+		{"dedupe with cluster", Options{Cluster: true, DedupeWindow: time.Minute}, "-dedupe cannot be combined"},
+		{"kafka with alerts", Options{Alerts: "rules.yaml", KafkaTopic: "t"}, "-kafka-topic cannot be combined"},
+		{"negative dedupe window", Options{DedupeWindow: -time.Second}, "-dedupe-window cannot be negative"},
+		{"merge and follow", Options{Merge: true, Follow: true}, "-merge cannot be combined"},
+		{"offset without merge", Options{Offsets: map[string]time.Duration{"a.log": time.Second}}, "-offset requires -merge"},
+		{"level and range", Options{Level: "INFO", LevelSet: true, MinLevel: "ERROR"}, "-level cannot be combined"},
+		{"bad range", Options{MinLevel: "ERROR", MaxLevel: "DEBUG"}, "ERROR"},
+		{"bad since", Options{Since: "yesterday-ish"}, "-since"},
+		{"bad query", Options{Query: "level >= NOPE"}, "column 10"},
+		{"bad zone", Options{TimeZone: "Mars/Olympus"}, "-tz"},
+		{"bad format", Options{Format: "xml"}, "-format"},
+		{"bad record start", Options{RecordStart: "("}, "-record-start"},
+		{"bad output", Options{Output: "yaml"}, "unknown output format"},
+		{"bad colour", Options{Color: "sometimes"}, "-color"},
+		{"bad bucket", Options{Stats: true, Bucket: "fortnight"}, "-bucket"},
+		{"bad redact pattern", Options{RedactPatterns: []string{"("}}, "redact pattern"},
+		{"kafka without client", Options{KafkaTopic: "t"}, "no Kafka client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipeline(tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewPipeline error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineOutput(t *testing.T) {
+	const (
+		emergency = "1970-01-01 00:00:00 EMERGENCY Failed to connect to database\n"
+		grpc      = "1970-01-01 00:00:00 CRITICAL gRPC client failed to connect to server\n"
+		user      = "1970-01-01 00:00:00 CRITICAL Failed to find user with ID '42'\n"
+	)
+	tests := []struct {
+		name        string
+		opts        Options
+		want        string // the whole output, or
+		wantPrefix  string // its start
+		wantMatched int
+	}{
+		{"default level", Options{Level: "CRITICAL"}, grpc + user, "", 2},
+		{"range", Options{Level: "CRITICAL", MinLevel: "WARNING"}, emergency + grpc + user, "", 3},
+		{"query drops the default level", Options{Level: "CRITICAL", Query: "message contains database"}, emergency, "", 1},
+		{"explicit level and query", Options{Level: "CRITICAL", LevelSet: true, Query: "message contains database"}, "", "", 0},
+		{"all levels with fields", Options{AllLevels: true, Fields: "level"}, "INFO\nEMERGENCY\nDEBUG\nCRITICAL\nCRITICAL\nINFO\nINFO\nINFO\n", "", 8},
+		{"ndjson", Options{Level: "EMERGENCY", Output: "ndjson"}, `{"time":"1970-01-01T00:00:00Z","level":"EMERGENCY","message":"Failed to connect to database","source":"../log.txt"}` + "\n", "", 1},
+		{"context", Options{Level: "EMERGENCY", Before: 1, After: 1}, "1970-01-01 00:00:00 INFO This is an informational message\n" + emergency + "1970-01-01 00:00:00 DEBUG This is an debug message\n", "", 1},
+		{"redacted", Options{Level: "CRITICAL", RedactPatterns: []string{`'\d+'`}}, grpc + "1970-01-01 00:00:00 CRITICAL Failed to find user with ID [REDACTED]\n", "", 2},
+		{"stats", Options{Stats: true, Bucket: "hour", Top: 1}, "", "Records:  8\n", 8},
+		{"cluster", Options{Cluster: true}, "", "4  INFO       This is an informational message\n", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, matched := runPipeline(t, tt.opts)
+			if tt.wantPrefix != "" {
+				if !strings.HasPrefix(got, tt.wantPrefix) {
+					t.Errorf("output = %q, want it to start with %q", got, tt.wantPrefix)
+				}
+			} else if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if matched != tt.wantMatched {
+				t.Errorf("Matched() = %d, want %d", matched, tt.wantMatched)
+			}
+		})
+	}
+}
+
+func TestPipelineReportsKafkaFailures(t *testing.T) {
+	producer := &memoryProducer{fail: func(KafkaMessage) error { return errors.New("broker down") }}
+	var errs bytes.Buffer
+	p, err := NewPipeline(Options{
+		Level:       "CRITICAL",
+		KafkaTopic:  "incidents",
+		NewProducer: func() (Producer, error) { return producer, nil },
+		Stderr:      &errs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background(), []string{"../log.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	err = p.Close()
+	if err == nil || err.Error() != "2 of 2 record(s) could not be delivered to Kafka" {
+		t.Errorf("Close error = %v", err)
+	}
+	if n := strings.Count(errs.String(), "kafka: "); n != 2 {
+		t.Errorf("stderr = %q, want two delivery errors", errs.String())
+	}
+}
+
+func TestPipelineMergeOffsets(t *testing.T) {
+	p, err := NewPipeline(Options{AllLevels: true, Merge: true, Offsets: map[string]time.Duration{"other.log": time.Second}, Stdout: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Run(context.Background(), []string{"../log.txt"})
+	if err == nil || !strings.Contains(err.Error(), "other.log is not an input") {
+		t.Errorf("Run error = %v, want an unknown -offset input", err)
+	}
+}
+
+func TestPipelineLevelAlias(t *testing.T) {
+	// -level WARN keeps selecting WARN lines now that they parse as WARNING.
+	var out bytes.Buffer
+	p, err := NewPipeline(Options{Level: "WARN", LevelSet: true, Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const input = "2024-05-01 10:00:00 WARN a\n2024-05-01 10:00:01 WARNING b\n2024-05-01 10:00:02 ERROR c\n"
+	if err := p.Filter.Run(strings.NewReader(input), "app.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-05-01 10:00:00 WARN a\n2024-05-01 10:00:01 WARNING b\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestPipelineDoesNotRedactAlertRules(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(rules, []byte("rules:\n  - name: any\n    query: level >= ERROR\n    actions:\n      - type: stderr\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline(Options{Alerts: rules, Redact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerter, ok := p.sink.(*Alerter)
+	if !ok {
+		t.Fatalf("sink = %T, want the Alerter itself", p.sink)
+	}
+	if alerter.Redactor == nil {
+		t.Error("the Alerter does not redact its payloads")
+	}
+}
+
+func TestPipelineDedupeComparesUnredactedRecords(t *testing.T) {
+	const input = "" +
+		"2024-05-01 10:00:00 ERROR login failed for alice@example.com\n" +
+		"2024-05-01 10:00:01 ERROR login failed for bob@example.com\n" +
+		"2024-05-01 10:00:02 ERROR login failed for bob@example.com\n"
+	// Both addresses mask to the same text, but only bob's line repeats;
+	// the summary is redacted like the records.
+	tests := []struct {
+		name    string
+		window  time.Duration
+		summary string
+	}{
+		{"consecutive", 0, "last message repeated 1 times"},
+		{"windowed", time.Minute, "message repeated 1 times: [login failed for [EMAIL]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			p, err := NewPipeline(Options{AllLevels: true, Dedupe: true, DedupeWindow: tt.window, Redact: true, Stdout: &out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Filter.Run(strings.NewReader(input), "app.log"); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := "2024-05-01 10:00:00 ERROR login failed for [EMAIL]\n" +
+				"2024-05-01 10:00:01 ERROR login failed for [EMAIL]\n" +
+				tt.summary + "\n"
+			if out.String() != want {
+				t.Errorf("output = %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package logfilter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A query is a boolean expression evaluated against each parsed Record, e.g.
+//
+//	level>=ERROR AND message =~ /connect/ AND NOT message contains "gRPC"
+//
+// Grammar (keywords are case-insensitive):
+//
+//	expr       = and { ("OR" | "||") and }
+//	and        = unary { ("AND" | "&&") unary }
+//	unary      = ("NOT" | "!") unary | "(" expr ")" | comparison
+//	comparison = field op value
+//	op         = "=" | "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "!~" | "contains" | "has"
+//	value      = word | "double quoted" | 'single quoted' | /regex/ | /regex/i
+//
+// Fields are level, message (msg), time (ts), source (file) and raw (line).
+// Any other name refers to an extracted field (see Record.Fields), which
+// supports every operator; ordering compares numerically when both sides
+// are numbers, as in status>=500.
+// "contains" tests for a substring, while "has" tests for whole words in any
+// case, so message has connect does not match "connection"; a sidecar
+// index (see BuildIndex) can answer "has" without scanning the file.
+// Levels compare by severity, so level>=WARNING also matches CRITICAL and
+// level=WARN matches WARNING, and time values accept everything
+// ParseTimeBound does, including relative durations.
+
+// Query is a compiled query expression.
+type Query interface {
+	// Match reports whether the record satisfies the expression.
+	Match(rec Record) bool
+	// String returns the expression in canonical, fully parenthesised form.
+	String() string
+}
+
+// QueryError describes a syntax or type error in a query and the 1-based
+// column where it was detected. Columns count characters, not bytes, so
+// the caret of QueryErrorContext lines up in queries with non-ASCII text.
+type QueryError struct {
+	Column int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query: column %d: %s", e.Column, e.Msg)
+}
+
+// QueryErrorContext renders src with a caret under the column reported by
+// err, for printing below the error message. It returns "" for other errors.
+func QueryErrorContext(src string, err error) string {
+	var qerr *QueryError
+	if !errors.As(err, &qerr) {
+		return ""
+	}
+	return "  " + src + "\n  " + strings.Repeat(" ", qerr.Column-1) + "^"
+}
+
+// ParseQuery compiles src into a Query. Time values are parsed with the
+// layout and location of p, relative to now.
+func ParseQuery(src string, p Parser, now time.Time) (Query, error) {
+	toks, err := lexQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	qp := &queryParser{toks: toks, parser: p, now: now}
+	q, err := qp.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := qp.peek(); t.kind != tokEOF {
+		return nil, qp.errorf(t, "unexpected %s after end of expression", t)
+	}
+	return q, nil
+}
+
+// ---- lexer ----
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokRegex
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // word, operator or unquoted string/regex body
+	flags string // regex flags such as "i"
+	col   int    // 1-based column of the first character
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	case tokRegex:
+		return fmt.Sprintf("regex /%s/", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lexQuery splits src into tokens.
+func lexQuery(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		col := utf8.RuneCountInString(src[:i]) + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", col: col})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", col: col})
+			i++
+		case c == '"' || c == '\'':
+			body, n, ok := scanDelimited(src[i:], c)
+			if !ok {
+				return nil, &QueryError{Column: col, Msg: "unterminated string"}
+			}
+			toks = append(toks, token{kind: tokString, text: body, col: col})
+			i += n
+		case c == '/':
+			body, n, ok := scanDelimited(src[i:], '/')
+			if !ok {
+				return nil, &QueryError{Column: col, Msg: "unterminated regex"}
+			}
+			i += n
+			flags := ""
+			for i < len(src) && strings.IndexByte("ims", src[i]) >= 0 {
+				flags += string(src[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokRegex, text: body, flags: flags, col: col})
+		case strings.IndexByte("=!<>&|", c) >= 0:
+			op := string(c)
+			if i+1 < len(src) && strings.IndexByte("=~&|", src[i+1]) >= 0 {
+				op = src[i : i+2]
+			}
+			switch op {
+			case "=", "==", "!=", "<", "<=", ">", ">=", "=~", "!~", "!", "&&", "||":
+			default:
+				return nil, &QueryError{Column: col, Msg: fmt.Sprintf("unknown operator %q", op)}
+			}
+			toks = append(toks, token{kind: tokOp, text: op, col: col})
+			i += len(op)
+		default:
+			start := i
+			for i < len(src) && strings.IndexByte(" \t\n()\"'=!<>&|", src[i]) < 0 {
+				i++
+			}
+			toks = append(toks, token{kind: tokWord, text: src[start:i], col: col})
+		}
+	}
+	return append(toks, token{kind: tokEOF, col: utf8.RuneCountInString(src) + 1}), nil
+}
+
+// scanDelimited reads a quoted or slash-delimited literal at the start of s.
+// A backslash escapes the delimiter; other escapes are kept verbatim so
+// regex escapes such as \d survive. It returns the body and the number of
+// bytes consumed including both delimiters.
+func scanDelimited(s string, delim byte) (body string, n int, ok bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			return b.String(), i + 1, true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
+
+// ---- parser ----
+
+type queryParser struct {
+	toks   []token
+	pos    int
+	parser Parser
+	now    time.Time
+}
+
+func (p *queryParser) peek() token { return p.toks[p.pos] }
+
+func (p *queryParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) errorf(t token, format string, args ...any) error {
+	return &QueryError{Column: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isKeyword reports whether t is the keyword kw or one of its symbolic forms.
+func isKeyword(t token, kw string, symbols ...string) bool {
+	if t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		return true
+	}
+	if t.kind == tokOp {
+		for _, s := range symbols {
+			if t.text == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for isKeyword(p.peek(), "OR", "||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orQuery{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for isKeyword(p.peek(), "AND", "&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andQuery{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (Query, error) {
+	t := p.peek()
+	switch {
+	case isKeyword(t, "NOT", "!"):
+		p.next()
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{q}, nil
+	case t.kind == tokLParen:
+		p.next()
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, p.errorf(closing, "expected \")\" to close \"(\" at column %d, found %s", t.col, closing)
+		}
+		return q, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (Query, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokWord {
+		return nil, p.errorf(fieldTok, "expected a field name, found %s", fieldTok)
+	}
+	field, ok := queryFields[strings.ToLower(fieldTok.text)]
+	if !ok {
+		field = extraField(fieldTok.text)
+	}
+
+	opTok := p.next()
+	op := opTok.text
+	switch {
+	case opTok.kind == tokWord && strings.EqualFold(op, "contains"):
+		op = "contains"
+	case opTok.kind == tokWord && strings.EqualFold(op, "has"):
+		op = "has"
+	case opTok.kind == tokOp && op == "==":
+		op = "="
+	case opTok.kind == tokOp && op != "!" && op != "&&" && op != "||":
+	default:
+		return nil, p.errorf(opTok, "expected an operator after %q, found %s", fieldTok.text, opTok)
+	}
+
+	valTok := p.next()
+	switch valTok.kind {
+	case tokWord, tokString, tokRegex:
+	default:
+		return nil, p.errorf(valTok, "expected a value after %q, found %s", op, valTok)
+	}
+
+	c := &compareQuery{field: field, op: op, value: valTok.text}
+	if err := p.compile(c, opTok, valTok); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compile checks that the operator suits the field and pre-parses the value
+// (regex, severity or time) so matching does no parsing per record.
+func (p *queryParser) compile(c *compareQuery, opTok, valTok token) error {
+	if c.op == "=~" || c.op == "!~" {
+		if c.field.kind != fieldText && c.field.kind != fieldExtra {
+			return p.errorf(opTok, "operator %s needs a text field, not %s", c.op, c.field.name)
+		}
+		expr := valTok.text
+		if valTok.flags != "" {
+			expr = "(?" + valTok.flags + ")" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return p.errorf(valTok, "invalid regex: %v", err)
+		}
+		c.re = re
+		return nil
+	}
+	if valTok.kind == tokRegex {
+		return p.errorf(valTok, "a regex needs the =~ or !~ operator, not %s", c.op)
+	}
+	if c.op == "has" {
+		if c.words = tokenize(c.value); len(c.words) == 0 {
+			return p.errorf(valTok, "operator has needs at least one word")
+		}
+	}
+
+	switch c.field.kind {
+	case fieldExtra:
+		if f, err := strconv.ParseFloat(c.value, 64); err == nil {
+			c.num, c.isNum = f, true
+		}
+	case fieldText:
+		if isOrdering(c.op) {
+			return p.errorf(opTok, "operator %s is not supported for %s", c.op, c.field.name)
+		}
+	case fieldLevel:
+		if c.op == "contains" || c.op == "has" {
+			return p.errorf(opTok, "operator %s is not supported for level", c.op)
+		}
+		// Known levels compare by severity for every operator, so level=WARN
+		// also matches WARNING. Other names can only be tested for equality.
+		sev, err := ParseSeverity(c.value)
+		if err != nil && isOrdering(c.op) {
+			return p.errorf(valTok, "%v", err)
+		}
+		c.sev, c.isSev = sev, err == nil
+	case fieldTime:
+		if c.op == "contains" || c.op == "has" {
+			return p.errorf(opTok, "operator %s is not supported for time", c.op)
+		}
+		t, err := ParseTimeBound(c.value, p.parser.Layout, p.location(), p.now)
+		if err != nil {
+			return p.errorf(valTok, "%v", err)
+		}
+		c.time = t
+	}
+	return nil
+}
+
+func (p *queryParser) location() *time.Location {
+	if p.parser.Location == nil {
+		return time.UTC
+	}
+	return p.parser.Location
+}
+
+func isOrdering(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
+
+// ---- evaluation ----
+
+type fieldKind int
+
+const (
+	fieldText fieldKind = iota
+	fieldLevel
+	fieldTime
+	fieldExtra // a Record.Fields entry
+)
+
+// queryField describes a Record field that can appear in a query.
+type queryField struct {
+	name string
+	kind fieldKind
+	get  func(Record) string
+}
+
+var queryFields = map[string]queryField{}
+
+// extraField looks name up in Record.Fields. A missing field reads as "".
+func extraField(name string) queryField {
+	return queryField{name: name, kind: fieldExtra, get: func(r Record) string { return r.Fields[name] }}
+}
+
+func init() {
+	text := func(name string, get func(Record) string, aliases ...string) {
+		f := queryField{name: name, kind: fieldText, get: get}
+		for _, n := range append(aliases, name) {
+			queryFields[n] = f
+		}
+	}
+	text("message", func(r Record) string { return r.Message }, "msg")
+	text("source", func(r Record) string { return r.Source }, "file")
+	text("raw", func(r Record) string { return r.Raw }, "line")
+	queryFields["level"] = queryField{name: "level", kind: fieldLevel, get: func(r Record) string { return r.Level }}
+	timeField := queryField{name: "time", kind: fieldTime}
+	queryFields["time"], queryFields["ts"] = timeField, timeField
+}
+
+type andQuery struct{ left, right Query }
+
+func (q andQuery) Match(r Record) bool { return q.left.Match(r) && q.right.Match(r) }
+func (q andQuery) String() string      { return "(" + q.left.String() + " AND " + q.right.String() + ")" }
+
+type orQuery struct{ left, right Query }
+
+func (q orQuery) Match(r Record) bool { return q.left.Match(r) || q.right.Match(r) }
+func (q orQuery) String() string      { return "(" + q.left.String() + " OR " + q.right.String() + ")" }
+
+type notQuery struct{ inner Query }
+
+func (q notQuery) Match(r Record) bool { return !q.inner.Match(r) }
+func (q notQuery) String() string      { return "NOT " + q.inner.String() }
+
+// compareQuery is a single "field op value" test.
+type compareQuery struct {
+	field queryField
+	op    string
+	value string
+	re    *regexp.Regexp // for =~ and !~
+	sev   Severity       // for level comparisons
+	time  time.Time      // for time comparisons
+	num   float64        // value as a number, for extracted fields
+	isNum bool
+	isSev bool     // the level value is a known severity
+	words []string // for has
+}
+
+func (c *compareQuery) String() string {
+	if c.re != nil {
+		return fmt.Sprintf("%s %s /%s/", c.field.name, c.op, c.re)
+	}
+	return fmt.Sprintf("%s %s %q", c.field.name, c.op, c.value)
+}
+
+func (c *compareQuery) Match(r Record) bool {
+	switch c.field.kind {
+	case fieldTime:
+		return compareOrdered(r.Time.Compare(c.time), c.op)
+	case fieldLevel:
+		if isOrdering(c.op) {
+			sev, err := ParseSeverity(r.Level)
+			if err != nil {
+				return false
+			}
+			return compareOrdered(int(sev)-int(c.sev), c.op)
+		}
+		eq := c.levelEqual(r.Level)
+		if c.op == "!=" {
+			return !eq
+		}
+		return eq
+	case fieldExtra:
+		if isOrdering(c.op) {
+			v, ok := r.Fields[c.field.name]
+			if !ok {
+				return false
+			}
+			// Numbers compare numerically, so status>=500 works; anything
+			// else compares as text.
+			if f, err := strconv.ParseFloat(v, 64); err == nil && c.isNum {
+				return compareOrdered(cmp.Compare(f, c.num), c.op)
+			}
+			return compareOrdered(strings.Compare(v, c.value), c.op)
+		}
+	}
+
+	v := c.field.get(r)
+	switch c.op {
+	case "=":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "contains":
+		return strings.Contains(v, c.value)
+	case "has":
+		return hasWords(v, c.words)
+	case "=~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	}
+	return false
+}
+
+// levelEqual reports whether level is the level of c: the same severity
+// when c names a known one, the same name in any case otherwise.
+func (c *compareQuery) levelEqual(level string) bool {
+	if c.isSev {
+		sev, err := ParseSeverity(level)
+		return err == nil && sev == c.sev
+	}
+	return strings.EqualFold(level, c.value)
+}
+
+// compareOrdered applies an ordering or equality operator to the result of
+// a three-way comparison (negative, zero or positive).
+func compareOrdered(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// QueryHighlight returns a regular expression matching the text that q
+// looks for in messages and raw lines (contains, has and =~ terms that are
+// not negated), for highlighting in the output. It returns nil when q has
+// no such terms.
+func QueryHighlight(q Query) *regexp.Regexp {
+	var terms []string
+	var walk func(q Query)
+	walk = func(q Query) {
+		switch q := q.(type) {
+		case andQuery:
+			walk(q.left)
+			walk(q.right)
+		case orQuery:
+			walk(q.left)
+			walk(q.right)
+		case *compareQuery:
+			if q.field.kind != fieldText || q.field.name == "source" {
+				return
+			}
+			switch q.op {
+			case "contains":
+				terms = append(terms, regexp.QuoteMeta(q.value))
+			case "has":
+				for _, w := range q.words {
+					terms = append(terms, `(?i:\b`+regexp.QuoteMeta(w)+`\b)`)
+				}
+			case "=~":
+				terms = append(terms, "(?:"+q.re.String()+")")
+			}
+		}
+	}
+	walk(q)
+	if len(terms) == 0 {
+		return nil
+	}
+	// Every term compiled on its own already, so the alternation does too.
+	return regexp.MustCompile(strings.Join(terms, "|"))
+}
@@ -0,0 +1,68 @@
+package logfilter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryLevelComparisons(t *testing.T) {
+	tests := []struct {
+		query string
+		level string
+		want  bool
+	}{
+		{"level = WARN", "WARNING", true},
+		{"level = warning", "WARN", true},
+		{"level == ERR", "ERROR", true},
+		{"level = WARN", "ERROR", false},
+		{"level != WARN", "WARNING", false},
+		{"level != WARN", "ERROR", true},
+		{"level >= WARN", "WARNING", true},
+		{"level < WARN", "WARNING", false},
+		{"level = emerg", "EMERGENCY", true},
+		// Names that are not severities compare as text.
+		{"level = TRACE", "trace", true},
+		{"level = TRACE", "DEBUG", false},
+		{"level != TRACE", "DEBUG", true},
+		// A known severity never equals an unknown level.
+		{"level = WARN", "TRACE", false},
+		{"level != WARN", "TRACE", true},
+	}
+	for _, tt := range tests {
+		q := mustQuery(t, tt.query)
+		if got := q.Match(Record{Level: tt.level}); got != tt.want {
+			t.Errorf("%s on %s = %v, want %v", tt.query, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestQueryErrorColumn(t *testing.T) {
+	tests := []struct {
+		query  string
+		column int
+		caret  string
+	}{
+		{"level >= NOPE", 10, "  level >= NOPE\n           ^"},
+		{`message contains "unterminated`, 18, "  message contains \"unterminated\n                   ^"},
+		// Columns count characters, so the caret stays under the token
+		// after multi-byte text.
+		{`message = "café" AND level >= NOPE`, 31, "  message = \"café\" AND level >= NOPE\n                                ^"},
+		{`message = "日本" bogus`, 16, "  message = \"日本\" bogus\n                 ^"},
+		{"message = ü (", 13, "  message = ü (\n              ^"},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query, Parser{}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("ParseQuery(%q) error = %v, want a QueryError", tt.query, err)
+			continue
+		}
+		if qerr.Column != tt.column {
+			t.Errorf("ParseQuery(%q) column = %d, want %d (%v)", tt.query, qerr.Column, tt.column, err)
+		}
+		if got := QueryErrorContext(tt.query, err); got != tt.caret {
+			t.Errorf("QueryErrorContext(%q) =\n%s\nwant\n%s", tt.query, got, tt.caret)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package logfilter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// RedactRule masks the text matched by Pattern. When Pattern has a group
+// named "secret", only that group is masked, so "Bearer abc" can keep its
+// scheme. Validate, if set, can reject a match, e.g. a digit run that fails
+// the Luhn check.
+type RedactRule struct {
+	Name     string // placeholder label, e.g. "EMAIL"
+	Pattern  *regexp.Regexp
+	Validate func(match string) bool
+}
+
+// DefaultRedactRules are the built-in detectors used by -redact: bearer
+// tokens, email addresses, card numbers and IPv4/IPv6 addresses. They run
+// in this order, so a token containing an address is masked as a whole.
+var DefaultRedactRules = []RedactRule{
+	{Name: "TOKEN", Pattern: regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`)},
+	{Name: "EMAIL", Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`)},
+	{Name: "CARD", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Validate: luhnValid},
+	{Name: "IP", Pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), Validate: isIP},
+	{Name: "IP", Pattern: ipv6Candidate, Validate: isIPv6},
+}
+
+// ipv6Candidate matches whole tokens of word characters, dots and colons,
+// so "std::vector::at" is one candidate rather than "d::" and "::a";
+// isIPv6 then rejects it, as well as times like 02:00:00.
+var ipv6Candidate = regexp.MustCompile(`[\w.]*:[\w:.]*[\w:]`)
+
+// Redactor masks secrets and personal data in records before they are
+// printed. Masked values become "[LABEL]", or "[LABEL:hash]" with Hash set,
+// where equal values get equal hashes so they can still be correlated.
+type Redactor struct {
+	Rules []RedactRule
+
+	// Hash appends a short hash of the masked value to the placeholder.
+	Hash bool
+
+	// Key keys the hash (HMAC-SHA256). Without it, common values such as
+	// IP addresses could be recovered by hashing candidates.
+	Key string
+}
+
+// NewRedactor returns a Redactor using the built-in rules plus one rule per
+// user pattern, labelled REDACTED.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	rules := append([]RedactRule(nil), DefaultRedactRules...)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", p, err)
+		}
+		rules = append(rules, RedactRule{Name: "REDACTED", Pattern: re})
+	}
+	return &Redactor{Rules: rules}, nil
+}
+
+// Redact returns s with every match of the rules masked.
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range r.Rules {
+		s = r.apply(rule, s)
+	}
+	return s
+}
+
+func (r *Redactor) apply(rule RedactRule, s string) string {
+	group := rule.Pattern.SubexpIndex("secret")
+	matches := rule.Pattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	out := make([]byte, 0, len(s))
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		if start < 0 || rule.Validate != nil && !rule.Validate(s[start:end]) {
+			continue
+		}
+		out = append(out, s[last:start]...)
+		out = append(out, r.placeholder(rule.Name, s[start:end])...)
+		last = end
+	}
+	return string(append(out, s[last:]...))
+}
+
+func (r *Redactor) placeholder(label, value string) string {
+	if !r.Hash {
+		return "[" + label + "]"
+	}
+	mac := hmac.New(sha256.New, []byte(r.Key))
+	mac.Write([]byte(value))
+	return "[" + label + ":" + hex.EncodeToString(mac.Sum(nil))[:8] + "]"
+}
+
+// RedactRecord masks the raw line, message and fields of rec.
+func (r *Redactor) RedactRecord(rec Record) Record {
+	rec.Raw = r.Redact(rec.Raw)
+	rec.Message = r.Redact(rec.Message)
+	if rec.Fields != nil {
+		// The map may be shared with the caller's copy of the record.
+		fields := make(map[string]string, len(rec.Fields))
+		for k, v := range rec.Fields {
+			fields[k] = r.Redact(v)
+		}
+		rec.Fields = fields
+	}
+	return rec
+}
+
+// RedactSink wraps a Sink so every record is redacted before it is
+// written. The Filter matches before its Sink sees a record, so -query and
+// -level still see the original text; sinks that match records themselves,
+// such as an Alerter, must not be wrapped (see Alerter.Redactor).
+type RedactSink struct {
+	Sink     Sink
+	Redactor *Redactor
+}
+
+// Write implements Sink.
+func (s *RedactSink) Write(rec Record) error {
+	return s.Sink.Write(s.Redactor.RedactRecord(rec))
+}
+
+// Close implements Sink.
+func (s *RedactSink) Close() error { return s.Sink.Close() }
+
+// Flush implements Flusher when the wrapped sink does.
+func (s *RedactSink) Flush() error {
+	if f, ok := s.Sink.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// WriteSeparator implements Separator when the wrapped sink does.
+func (s *RedactSink) WriteSeparator() error {
+	if sep, ok := s.Sink.(Separator); ok {
+		return sep.WriteSeparator()
+	}
+	return nil
+}
+
+func isIP(s string) bool { return net.ParseIP(s) != nil }
+
+// isIPv6 reports whether s is an IPv6 address with at least two non-empty
+// groups, which leaves "::" and fragments like "d::" in C++ names alone
+// (and the loopback "::1", which identifies nobody).
+func isIPv6(s string) bool {
+	if net.ParseIP(s) == nil {
+		return false
+	}
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 2
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum used by
+// payment card numbers. Spaces and dashes are ignored.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,32 @@
+package logfilter
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	r, err := NewRedactor([]string{`session=(?P<secret>\w+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"panic in std::vector::at", "panic in std::vector::at"},
+		{"a :: b d:: c", "a :: b d:: c"},
+		{"started at 02:00:00", "started at 02:00:00"},
+		{"from 2001:db8::1.", "from [IP]."},
+		{"peer [fe80::1ff:fe23:4567:890a]:443", "peer [[IP]]:443"},
+		{"loopback ::1", "loopback ::1"},
+		{"client 10.0.0.12 connected", "client [IP] connected"},
+		{"version 1.2.3.400", "version 1.2.3.400"},
+		{"mail bob@example.com now", "mail [EMAIL] now"},
+		{"Authorization: Bearer abc.def-ghi", "Authorization: Bearer [TOKEN]"},
+		{"card 4111 1111 1111 1111 used", "card [CARD] used"},
+		{"order 1234567890123 shipped", "order 1234567890123 shipped"},
+		{"session=deadbeef ok", "session=[REDACTED] ok"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
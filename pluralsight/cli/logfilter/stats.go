@@ -0,0 +1,190 @@
+package logfilter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats accumulates summary counts over a stream of records.
+type Stats struct {
+	Bucket time.Duration // width of the time histogram buckets
+
+	Total    int
+	Invalid  int
+	First    time.Time
+	Last     time.Time
+	levels   map[string]int
+	buckets  map[time.Time]int
+	messages map[string]int
+}
+
+// NewStats returns an empty Stats grouping timestamps into buckets of the
+// given width (e.g. time.Hour).
+func NewStats(bucket time.Duration) *Stats {
+	return &Stats{
+		Bucket:   bucket,
+		levels:   map[string]int{},
+		buckets:  map[time.Time]int{},
+		messages: map[string]int{},
+	}
+}
+
+// ParseBucket converts a -bucket value ("hour", "minute" or a Go duration
+// such as "15m") into a bucket width.
+func ParseBucket(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "hour", "h":
+		return time.Hour, nil
+	case "minute", "min", "m":
+		return time.Minute, nil
+	case "day", "d":
+		return 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid bucket %q (want hour, minute, day or a positive duration)", value)
+	}
+	return d, nil
+}
+
+// Write implements Sink by counting the record.
+func (s *Stats) Write(rec Record) error {
+	s.Add(rec)
+	return nil
+}
+
+// Close implements Sink. The report is printed separately with WriteText
+// or WriteJSON.
+func (s *Stats) Close() error { return nil }
+
+// Add counts one record.
+func (s *Stats) Add(rec Record) {
+	s.Total++
+	s.levels[rec.Level]++
+	s.messages[rec.Message]++
+	// Truncate rounds down to a multiple of the bucket width since the zero
+	// time, which lines buckets up with whole hours and minutes in UTC.
+	s.buckets[rec.Time.Truncate(s.Bucket)]++
+	if s.First.IsZero() || rec.Time.Before(s.First) {
+		s.First = rec.Time
+	}
+	if s.Last.IsZero() || rec.Time.After(s.Last) {
+		s.Last = rec.Time
+	}
+}
+
+// Count is a key with the number of records it was seen in.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Levels returns the per-level counts ordered by severity, most severe
+// first; unknown levels follow in alphabetical order.
+func (s *Stats) Levels() []Count {
+	counts := make([]Count, 0, len(s.levels))
+	for level, n := range s.levels {
+		counts = append(counts, Count{level, n})
+	}
+	slices.SortFunc(counts, func(a, b Count) int {
+		sa, _ := ParseSeverity(a.Key)
+		sb, _ := ParseSeverity(b.Key)
+		return cmp.Or(cmp.Compare(sb, sa), strings.Compare(a.Key, b.Key))
+	})
+	return counts
+}
+
+// Buckets returns the time histogram in chronological order.
+func (s *Stats) Buckets() []Count {
+	times := make([]time.Time, 0, len(s.buckets))
+	for t := range s.buckets {
+		times = append(times, t)
+	}
+	slices.SortFunc(times, time.Time.Compare)
+	counts := make([]Count, len(times))
+	for i, t := range times {
+		counts[i] = Count{t.Format(time.RFC3339), s.buckets[t]}
+	}
+	return counts
+}
+
+// TopMessages returns the n most frequent messages, ties broken
+// alphabetically. n <= 0 returns all of them.
+func (s *Stats) TopMessages(n int) []Count {
+	counts := make([]Count, 0, len(s.messages))
+	for msg, c := range s.messages {
+		counts = append(counts, Count{msg, c})
+	}
+	slices.SortFunc(counts, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Key, b.Key))
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// WriteText prints a human-readable report with aligned columns.
+func (s *Stats) WriteText(w io.Writer, topN int) error {
+	// tabwriter pads tab-separated cells into aligned columns.
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Records:\t%d\n", s.Total)
+	if s.Invalid > 0 {
+		fmt.Fprintf(tw, "Unparsable lines:\t%d\n", s.Invalid)
+	}
+	if s.Total > 0 {
+		fmt.Fprintf(tw, "First:\t%s\n", s.First.Format(time.RFC3339))
+		fmt.Fprintf(tw, "Last:\t%s\n", s.Last.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(tw, "\nBy level:")
+	for _, c := range s.Levels() {
+		fmt.Fprintf(tw, "  %s\t%d\n", c.Key, c.Count)
+	}
+
+	fmt.Fprintf(tw, "\nBy %s:\n", s.Bucket)
+	for _, c := range s.Buckets() {
+		fmt.Fprintf(tw, "  %s\t%d\n", c.Key, c.Count)
+	}
+
+	fmt.Fprintf(tw, "\nTop %d messages:\n", topN)
+	for _, c := range s.TopMessages(topN) {
+		fmt.Fprintf(tw, "  %d\t%s\n", c.Count, c.Key)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON prints the report as a single JSON object.
+func (s *Stats) WriteJSON(w io.Writer, topN int) error {
+	report := struct {
+		Total    int     `json:"total"`
+		Invalid  int     `json:"invalid"`
+		First    string  `json:"first,omitempty"`
+		Last     string  `json:"last,omitempty"`
+		Bucket   string  `json:"bucket"`
+		Levels   []Count `json:"levels"`
+		Buckets  []Count `json:"buckets"`
+		Messages []Count `json:"top_messages"`
+	}{
+		Total:    s.Total,
+		Invalid:  s.Invalid,
+		Bucket:   s.Bucket.String(),
+		Levels:   s.Levels(),
+		Buckets:  s.Buckets(),
+		Messages: s.TopMessages(topN),
+	}
+	if s.Total > 0 {
+		report.First = s.First.Format(time.RFC3339)
+		report.Last = s.Last.Format(time.RFC3339)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(report)
+}
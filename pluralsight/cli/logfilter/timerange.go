@@ -0,0 +1,84 @@
+package logfilter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// layoutNames are the shorthand names accepted by ResolveLayout in addition
+// to literal Go layouts.
+var layoutNames = map[string]string{
+	"default":     DefaultTimeLayout,
+	"datetime":    time.DateTime,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"stamp":       time.Stamp,
+	"stampmilli":  time.StampMilli,
+	"kitchen":     time.Kitchen,
+}
+
+// ResolveLayout expands a layout setting: either one of the names in
+// layoutNames (case-insensitive) or a Go reference-time layout.
+func ResolveLayout(value string) string {
+	if layout, ok := layoutNames[strings.ToLower(value)]; ok {
+		return layout
+	}
+	return value
+}
+
+// TimeRange keeps records whose timestamp is at or after Since and before
+// Until. A zero bound leaves that side open.
+type TimeRange struct {
+	Since, Until time.Time
+}
+
+// IsZero reports whether the range has no bounds at all.
+func (r TimeRange) IsZero() bool {
+	return r.Since.IsZero() && r.Until.IsZero()
+}
+
+// Match implements Matcher.
+func (r TimeRange) Match(rec Record) bool { return r.Contains(rec.Time) }
+
+// Contains reports whether t falls inside the range. Until is exclusive, so
+// a range from 02:00 to 03:00 covers exactly one hour.
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.Since.IsZero() && t.Before(r.Since) {
+		return false
+	}
+	if !r.Until.IsZero() && !t.Before(r.Until) {
+		return false
+	}
+	return true
+}
+
+// ParseTimeBound parses a range bound such as the CLI's -since and -until
+// values. It accepts:
+//   - a duration relative to now, such as "2h" or "90m" (meaning 2h ago);
+//   - a timestamp in the log's own layout;
+//   - RFC 3339 ("2024-05-01T02:00:00Z"), "2006-01-02 15:04", or a date alone;
+//   - a time of day ("02:00" or "02:00:00"), meaning that time today.
+//
+// Values without an explicit offset are interpreted in loc.
+func ParseTimeBound(value, layout string, loc *time.Location, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, l := range []string{layout, time.RFC3339Nano, time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(l, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, l := range []string{time.TimeOnly, "15:04"} {
+		if t, err := time.ParseInLocation(l, value, loc); err == nil {
+			y, m, d := now.In(loc).Date()
+			return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time or duration", value)
+}
@@ -1,73 +1,327 @@
 // Use this command to parse files searching for parameter match and print it to stdout
 // Default parameter: CRITICAL
+// Files are given as arguments (globs, directories with -r, and "-" for stdin); log.txt is read by default.
+// Each line is parsed as "YYYY-MM-DD HH:MM:SS LEVEL message" and matched on its level field only.
+// The parsing and filtering live in the logfilter package; this file only maps flags onto it.
+// The tool is split into subcommands (filter, stats, tail, index, convert, validate), each
+// with its own flags; without one, the arguments go to filter as they always have.
+// Exit status as in grep: 0 when something matched, 1 when nothing did, 2 on errors.
 // Sample trigger commands:
 //    go run .
 //    go run . -level DEBUG
+//    go run . -show-invalid
+//    go run . -min-level WARNING
+//    go run . -min-level INFO -max-level ERROR
+//    go run . -H 'logs/*.txt' log.txt
+//    go run . -r -min-level ERROR /var/log/myapp
+//    cat log.txt | go run . -level INFO -
+//    go run . -f -min-level WARNING /var/log/myapp/app.log
+//    go run . -since 2h -min-level ERROR app.log
+//    go run . -since "1970-01-01 00:00:00" -until "1970-01-01 01:00:00" -min-level DEBUG
+//    go run . -time-layout rfc3339 -tz Europe/Berlin app.log
+//    go run . -query 'level>=ERROR AND message =~ /connect/ AND NOT message contains "gRPC"'
+//    go run . -min-level ERROR -output ndjson | jq .message
+//    go run . -stats -bucket minute -top 5
+//    go run . -cluster
+//    go run . -workers 8 -min-level ERROR huge.log
+//    go run . -B 3 -A 1 -level CRITICAL
+//    go run . -multiline -level CRITICAL service.log
+//    go run . -record-start '^\d{4}-\d{2}-\d{2} ' -level ERROR java.log
+//    go run . -format json -min-level WARNING service.jsonl
+//    go run . -min-level ERROR access.log app.logfmt syslog.txt
+//    go run . -extract "ID '(?P<user_id>\d+)'" -query 'user_id = 42' -fields ts,level,user_id
+//    go run . -kv -query 'status >= 500' -fields ts,status,path -output csv app.log
+//    go run . -redact -redact-hash -min-level ERROR app.log
+//    go run . -redact-pattern 'session=(?P<secret>\w+)' app.log
+//    go run . -f -alerts rules.yaml /var/log/myapp/app.log
+//    go run . index huge.log && go run . -query 'level>=ERROR AND message has timeout' huge.log
+//    go run . filter -min-level ERROR app.log && echo "errors found"
+//    go run . stats -bucket minute app.log
+//    go run . tail -min-level WARNING /var/log/myapp/app.log
+//    go run . convert -format logfmt -output csv app.logfmt > app.csv
+//    go run . validate -format json service.jsonl
+//    go run . -merge -offset db.log=-1.5s -min-level WARNING api.log db.log worker.jsonl
+//    go run . -dedupe -min-level DEBUG
+//    go run . tail -min-level CRITICAL -kafka-topic incidents /var/log/myapp/app.log
+//    go run . config show
+//    go run . -color always -query 'message has connect' | less -R
+//    LOGFILTER_MIN_LEVEL=ERROR go run . -query @slow-db
+//    go run . help
 
 package main
 
 import (
-	"bufio"   // Provides buffered I/O, useful for efficient reading of files line by line
-	"flag"    // Implements command-line flag parsing (e.g., -level DEBUG)
-	"fmt"     // Implements formatted I/O (for printing output)
-	"log"     // Implements simple logging, used here for error handling
-	"os"      // Provides a platform-independent interface to operating system functionality (like file access)
-	"strings" // Implements simple functions for manipulating strings (like checking for substrings)
+	"context"   // Carries the cancellation signal to the goroutines in follow mode
+	"flag"      // Implements command-line flag parsing (e.g., -level DEBUG)
+	"fmt"       // Implements formatted I/O (for printing output)
+	"log"       // Implements simple logging, used here for error handling
+	"os"        // Provides a platform-independent interface to operating system functionality (like file access)
+	"os/signal" // Turns Ctrl+C into a context cancellation
+	"strings"   // Recognises saved query references such as -query @slow-db
+	"syscall"   // Provides the SIGTERM signal value
+
+	"cli/logfilter" // The parsing, filtering and output logic behind this command
+)
+
+// Exit statuses, as in grep. flag.ExitOnError also exits with 2 on bad flags.
+const (
+	exitMatch   = 0 // at least one record matched
+	exitNoMatch = 1 // nothing matched (or, for validate, some lines are invalid)
+	exitError   = 2 // the command failed
 )
 
+// command is a subcommand of the tool.
+type command struct {
+	name    string
+	summary string // one line, shown by `help`
+	args    string // argument synopsis for the usage line
+	setup   func(o *options, fs *flag.FlagSet)
+	run     func(c command, args []string) int // set for commands that are not filters
+
+	// requiresFiles commands fail without file arguments instead of
+	// reading the configured or default inputs.
+	requiresFiles bool
+}
+
+// commands lists the subcommands. The filtering ones share runFilter and
+// differ in the flags they register and the mode they preset. It is filled
+// in by init because config reads it to check setting names.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "filter", summary: "Print the records matching the given filters (the default)", args: "[FILE...]", setup: func(o *options, fs *flag.FlagSet) {
+			o.inputFlags(fs)
+			o.mergeFlags(fs)
+			o.parseFlags(fs)
+			o.extractFlags(fs)
+			o.filterFlags(fs)
+			o.contextFlags(fs)
+			o.followFlags(fs, true)
+			o.outputFlags(fs)
+			o.redactFlags(fs)
+			o.summaryFlags(fs, true)
+			o.alertFlags(fs)
+			o.kafkaFlags(fs)
+		}},
+		{name: "stats", summary: "Summarise records: counts per level and time bucket, top messages", args: "[FILE...]", setup: func(o *options, fs *flag.FlagSet) {
+			o.Stats = true
+			o.inputFlags(fs)
+			o.mergeFlags(fs)
+			o.parseFlags(fs)
+			o.extractFlags(fs)
+			o.filterFlags(fs)
+			o.redactFlags(fs)
+			o.summaryFlags(fs, false)
+			fs.StringVar(&o.Output, "output", o.Output, "Report format: text or json")
+		}},
+		{name: "tail", summary: "Follow files and print matching records as they are appended", args: "FILE...", requiresFiles: true, setup: func(o *options, fs *flag.FlagSet) {
+			o.Follow = true
+			o.inputFlags(fs)
+			o.parseFlags(fs)
+			o.extractFlags(fs)
+			o.filterFlags(fs)
+			o.contextFlags(fs)
+			o.followFlags(fs, false)
+			o.outputFlags(fs)
+			o.redactFlags(fs)
+			o.alertFlags(fs)
+			o.kafkaFlags(fs)
+		}},
+		{name: "index", summary: "Build sidecar indexes that speed up later queries", args: "FILE...", requiresFiles: true, run: runIndex, setup: func(o *options, fs *flag.FlagSet) {
+			// The parsing settings must match the ones given to the filter later,
+			// otherwise the index is ignored there.
+			fs.BoolVar(&o.recursive, "r", o.recursive, "Index all files under directory arguments recursively")
+			fs.StringVar(&o.Bucket, "bucket", o.Bucket, "Width of the time buckets in the index: hour, minute, day or a duration like 15m")
+			fs.StringVar(&o.Format, "format", o.Format, "Input format: auto, plain, json, logfmt, syslog or access")
+			fs.StringVar(&o.TimeLayout, "time-layout", o.TimeLayout, "Timestamp layout of the logs, as a Go layout or a name like rfc3339")
+			fs.StringVar(&o.TimeZone, "tz", o.TimeZone, "Time zone of timestamps without an offset (e.g. Local, Europe/Berlin)")
+		}},
+		{name: "convert", summary: "Re-emit every record in another format, e.g. logfmt to CSV", args: "[FILE...]", setup: func(o *options, fs *flag.FlagSet) {
+			o.AllLevels = true
+			o.Output = "ndjson"
+			o.inputFlags(fs)
+			o.mergeFlags(fs)
+			o.parseFlags(fs)
+			o.extractFlags(fs)
+			o.filterFlags(fs)
+			o.outputFlags(fs)
+			o.redactFlags(fs)
+		}},
+		{name: "validate", summary: "Report lines that do not parse in the expected format", args: "[FILE...]", run: runValidate, setup: func(o *options, fs *flag.FlagSet) {
+			fs.BoolVar(&o.recursive, "r", o.recursive, "Validate all files under directory arguments recursively")
+			o.parseFlags(fs)
+			fs.BoolVar(&o.quiet, "q", o.quiet, "Print nothing; only set the exit status")
+		}},
+		{name: "config", summary: "Show the effective configuration and where each value comes from", args: "show [flags]", run: runConfig, setup: func(o *options, fs *flag.FlagSet) {}},
+	}
+}
+
 func main() {
-	// 1. Command-Line Flag Setup
-	// flag.String defines a string flag named "level".
-	// The second argument ("CRITICAL") is the default value if the flag is not provided.
-	// The third argument is the usage message printed if the user asks for help.
-	level := flag.String("level", "CRITICAL", "Log level to filter for")
-
-	// flag.Parse executes the command-line parsing.
-	// It must be called before the flag variables are accessed.
-	flag.Parse()
-	// 2. File Opening
-	// os.Open attempts to open the file named "log.txt".
-	file, err := os.Open("./logs.txt")
-
-	// Standard error checking: if an error occurred (e.g., file not found),
-	// log.Fatal prints the error and exits the program immediately.
-	if err != nil {
-		log.Fatal(err)
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to the subcommand named by the first argument and returns
+// the exit status.
+func run(args []string) int {
+	if len(args) > 0 {
+		if args[0] == "help" || args[0] == "-help" || args[0] == "--help" {
+			usage(os.Stdout)
+			return exitMatch
+		}
+		for _, c := range commands {
+			if c.name == args[0] {
+				if c.run != nil {
+					return c.run(c, args[1:])
+				}
+				return filterCommand(c, args[1:])
+			}
+		}
 	}
+	// Without a subcommand the arguments are those of filter, so scripts
+	// written before subcommands existed keep working.
+	return filterCommand(commands[0], args)
+}
 
-	// defer schedules a function call (file.Close()) to be run after
-	// the surrounding function (main) finishes executing. This ensures the file
-	// is always closed, even if a runtime error occurs.
-	defer file.Close()
+// usage lists the subcommands.
+func usage(w *os.File) {
+	fmt.Fprintf(w, "Usage: %s [COMMAND] [flags] [FILE...]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nWithout a command, filter is run. Use \"%s COMMAND -h\" for the flags of a command.\n", os.Args[0])
+}
 
-	// 3. Buffered Reader Initialization
-	// bufio.NewReader wraps the file handle, providing a buffered reading mechanism.
-	// This makes reading line-by-line much more efficient than reading directly from the OS.
-	bufReader := bufio.NewReader(file)
+// newFlagSet returns a flag set whose -h output names the command.
+func newFlagSet(c command) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n", os.Args[0], c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	// 4. File Iteration Loop
-	// This is a classic Go idiom for reading a file stream until the end.
+// fatal and fatalf report an error and exit with exitError. log.Fatal
+// would exit with 1, which here means "no match".
+func fatal(v ...any) {
+	log.Print(v...)
+	os.Exit(exitError)
+}
 
-	// Initialization (before the first check): Read the first line of the file.
-	// '\n' specifies the delimiter; ReadString reads until the next newline character.
-	// 'line' holds the content, 'err' holds the error (e.g., io.EOF at end of file).
-	for line, err := bufReader.ReadString('\n');
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(exitError)
+}
 
-	// Condition (checked before each loop): Continue looping as long as no error has occurred.
-	// When the end of the file is reached, err will be io.EOF, and the loop will stop.
-	err == nil;
+// filterCommand runs one of the filtering commands.
+func filterCommand(c command, args []string) int {
+	// 1. Command-Line Flag Setup
+	// Every command has its own flag set, so `stats -h` only lists the flags
+	// stats understands. fs.Parse executes the command-line parsing; it must be
+	// called before the option values are used.
+	o := newOptions()
+	fs := newFlagSet(c)
+	c.setup(o, fs)
+	sources := parseArgs(fs, args, o)
 
-	// Post-iteration (run at the end of the block): Read the next line for the next iteration.
-	line, err = bufReader.ReadString('\n') {
+	// Commands whose files are required, like tail, do not fall back to the
+	// default inputs: following ./log.txt forever is never what was meant.
+	if c.requiresFiles && fs.NArg() == 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	// fs.Visit only walks flags that have been set, by the command line, the
+	// environment or the config file, which tells an explicit -level apart
+	// from its CRITICAL default.
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// -level and a level range exclude each other. When they come from
+	// different places, the one with the higher precedence wins: a -level
+	// flag replaces a configured range, LOGFILTER_MIN_LEVEL replaces a level
+	// from the config file, and so on. From the same place they conflict.
+	levelRank := sourceRank(sources["level"])
+	rangeRank := max(sourceRank(sources["min-level"]), sourceRank(sources["max-level"]))
+	switch {
+	case levelRank > rangeRank:
+		o.MinLevel, o.MaxLevel = "", ""
+	case rangeRank > levelRank:
+		delete(explicit, "level")
+	}
+	o.LevelSet = explicit["level"]
 
-		// 5. Filtering and Output
-		// The * operator dereferences the 'level' pointer to get its actual string value.
-		// strings.Contains checks if the current 'line' contains the filter log level (e.g., "CRITICAL").
-		if strings.Contains(line, *level) {
+	// -C sets both sides; an explicit -A or -B still wins for its own side.
+	if !explicit["B"] {
+		o.Before = o.contextLines
+	}
+	if !explicit["A"] {
+		o.After = o.contextLines
+	}
+	return runFilter(o, fs.Args())
+}
 
-			// fmt.Print writes the matching line to standard output (stdout).
-			// Since ReadString('\n') includes the newline, we use Print instead of Println.
-			fmt.Print(line)
+// runFilter reads the inputs named by args with the given options and
+// returns the exit status.
+func runFilter(o *options, args []string) int {
+	// -query @name runs a query saved in the config file.
+	if name, ok := strings.CutPrefix(o.Query, "@"); ok {
+		saved, ok := o.queries[name]
+		if !ok {
+			fatalf("-query: no saved query %q in %s", name, o.configPath)
+		}
+		o.Query = saved
+	}
+	if o.KafkaTopic != "" {
+		if newKafkaProducer == nil {
+			fatal("-kafka-topic: this program was built without Kafka support (rebuild with CGO_ENABLED=1)")
 		}
+		o.NewProducer = func() (logfilter.Producer, error) { return newKafkaProducer(o.kafkaBrokers, o.KafkaBatch) }
+	}
+
+	// 2. Pipeline Construction
+	// logfilter checks the options and builds the matchers and the sink before any
+	// input is read, so an unknown format or a bad query fails fast.
+	pipeline, err := logfilter.NewPipeline(o.Options)
+	if err != nil {
+		fatal(err)
+	}
+
+	// 3. Input Selection
+	// Positional arguments name the files to read. Without any, the configured inputs
+	// are read, or else the sample log.txt next to the program.
+	if len(args) == 0 {
+		args = o.defaultInputs
+	}
+	inputs, err := logfilter.ExpandInputs(args, o.recursive)
+	if err != nil {
+		fatal(err)
+	}
+
+	// 4. Reading
+	// Followed inputs are read until an interrupt (Ctrl+C) or SIGTERM: signal.NotifyContext
+	// cancels ctx when one of the signals arrives, which lets every follower return and the
+	// summary be printed before exiting.
+	ctx := context.Background()
+	if o.Follow {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+	if err := pipeline.Run(ctx, inputs); err != nil {
+		fatal(err)
+	}
+
+	// 5. Reports
+	// Close completes structured formats and prints the summary reports.
+	if err := pipeline.Close(); err != nil {
+		fatal(err)
+	}
+
+	// Like grep, the exit status tells scripts whether anything matched.
+	if pipeline.Matched() == 0 {
+		return exitNoMatch
 	}
+	return exitMatch
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runMainEnv makes the test binary act as the command; fatal exits the
+// process, so every run happens in a child process.
+const runMainEnv = "RUN_LOGFILTER_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		os.Exit(run(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args and stdin in a child process. Only
+// the LOGFILTER_ variables in env are set, and without LOGFILTER_CONFIG the
+// config file does not exist.
+func runMain(t *testing.T, env []string, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, runMainEnv+"=1", envPrefix+"CONFIG="+filepath.Join(t.TempDir(), "none.yaml"))
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errs
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		code = exit.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return out.String(), errs.String(), code
+}
+
+// writeTemp writes a file into a temporary directory and returns its path.
+func writeTemp(t *testing.T, name, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExitCodes(t *testing.T) {
+	sample, err := os.ReadFile("log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := writeTemp(t, "empty.log", "")
+	garbage := writeTemp(t, "garbage.log", "not a log line\n"+string(sample))
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  int
+	}{
+		{"help", "", []string{"help"}, exitMatch},
+
+		{"no command", "", []string{"log.txt"}, exitMatch},
+		{"filter match", "", []string{"filter", "-level", "EMERGENCY", "log.txt"}, exitMatch},
+		{"filter no match", "", []string{"filter", "-level", "ALERT", "log.txt"}, exitNoMatch},
+		{"filter bad query", "", []string{"filter", "-query", "level >=", "log.txt"}, exitError},
+		{"filter unknown flag", "", []string{"filter", "-bogus", "log.txt"}, exitError},
+		{"filter missing file", "", []string{"filter", "missing.log"}, exitError},
+
+		{"stats", "", []string{"stats", "log.txt"}, exitMatch},
+		{"stats nothing counted", "", []string{"stats", "-query", "message contains nowhere", "log.txt"}, exitNoMatch},
+		{"stats has no -cluster", "", []string{"stats", "-cluster", "log.txt"}, exitError},
+
+		{"tail match", string(sample), []string{"tail", "-level", "CRITICAL", "-"}, exitMatch},
+		{"tail no match", string(sample), []string{"tail", "-level", "ALERT", "-"}, exitNoMatch},
+		{"tail without files", "", []string{"tail"}, exitError},
+
+		{"index", "", []string{"index", writeTemp(t, "app.log", string(sample))}, exitMatch},
+		{"index without files", "", []string{"index"}, exitError},
+		{"index stdin", string(sample), []string{"index", "-"}, exitError},
+
+		{"convert", "", []string{"convert", "log.txt"}, exitMatch},
+		{"convert nothing", "", []string{"convert", empty}, exitNoMatch},
+		{"convert bad output", "", []string{"convert", "-output", "yaml", "log.txt"}, exitError},
+
+		{"validate", "", []string{"validate", "log.txt"}, exitMatch},
+		{"validate invalid lines", "", []string{"validate", garbage}, exitNoMatch},
+		{"validate bad format", "", []string{"validate", "-format", "xml", "log.txt"}, exitError},
+
+		{"config show", "", []string{"config", "show"}, exitMatch},
+		{"config without show", "", []string{"config"}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, nil, tt.stdin, tt.args...)
+			if code != tt.want {
+				t.Errorf("exit status = %d, want %d; stderr:\n%s", code, tt.want, stderr)
+			}
+		})
+	}
+}
+
+// showSetting runs `config show` and returns the line of the named setting
+// with its columns separated by single spaces.
+func showSetting(t *testing.T, env []string, args []string, name string) string {
+	t.Helper()
+	stdout, stderr, code := runMain(t, env, "", append([]string{"config", "show"}, args...)...)
+	if code != exitMatch {
+		t.Fatalf("config show: exit status %d; stderr:\n%s", code, stderr)
+	}
+	for line := range strings.Lines(stdout) {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return strings.Join(fields, " ")
+		}
+	}
+	t.Fatalf("config show does not list %s:\n%s", name, stdout)
+	return ""
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string // config file contents, if any
+		env     []string
+		args    []string
+		setting string
+		want    string // the config show line; CONFIG stands for the file's path
+	}{
+		{"default", "", nil, nil, "level", "level CRITICAL default"},
+		{"config file", "level: ERROR\n", nil, nil, "level", "level ERROR CONFIG"},
+		{"env over config", "level: ERROR\n", []string{"LOGFILTER_LEVEL=WARNING"}, nil, "level", "level WARNING env LOGFILTER_LEVEL"},
+		{"flag over env and config", "level: ERROR\n", []string{"LOGFILTER_LEVEL=WARNING"}, []string{"-level", "ALERT"}, "level", "level ALERT flag"},
+		{"env without config", "", []string{"LOGFILTER_MIN_LEVEL=NOTICE"}, nil, "min-level", "min-level NOTICE env LOGFILTER_MIN_LEVEL"},
+		{"dashes and underscores in the file", "show_invalid: true\n", nil, nil, "show-invalid", "show-invalid true CONFIG"},
+		{"env turns off a config bool", "redact: true\n", []string{"LOGFILTER_REDACT=false"}, nil, "redact", "redact false env LOGFILTER_REDACT"},
+		{"repeatable flag from a list", "redact-pattern: ['a\\d+', 'b\\d+']\n", nil, nil, "redact-pattern", `redact-pattern a\d+ b\d+ CONFIG`},
+		{"flag replaces a configured list", "redact-pattern: ['a\\d+']\n", nil, []string{"-redact-pattern", `c\d+`}, "redact-pattern", `redact-pattern c\d+ flag`},
+		{"inputs from config", "inputs: [a.log, b.log]\n", nil, nil, "inputs", "inputs a.log b.log CONFIG"},
+		{"inputs from env", "inputs: [a.log]\n", []string{"LOGFILTER_INPUTS=c.log" + string(filepath.ListSeparator) + "d.log"}, nil, "inputs", "inputs c.log d.log env LOGFILTER_INPUTS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			want := tt.want
+			if tt.config != "" {
+				path := writeTemp(t, "config.yaml", tt.config)
+				env = append([]string{envPrefix + "CONFIG=" + path}, env...)
+				want = strings.ReplaceAll(want, "CONFIG", path)
+			}
+			if got := showSetting(t, env, tt.args, tt.setting); got != want {
+				t.Errorf("config show: %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigLevelPrecedence(t *testing.T) {
+	// -level and a level range from different places: the one with the
+	// higher precedence wins. From the same place they conflict.
+	input := writeTemp(t, "app.log", "2024-05-01 10:00:00 WARNING w\n2024-05-01 10:00:01 ERROR e\n2024-05-01 10:00:02 CRITICAL c\n")
+	tests := []struct {
+		name   string
+		config string
+		env    []string
+		args   []string
+		want   string // the printed messages, or "exit 2"
+	}{
+		{"default level", "", nil, nil, "c"},
+		{"flag level over config range", "min-level: WARNING\n", nil, []string{"-level", "ERROR"}, "e"},
+		{"env range over config level", "level: ERROR\n", []string{"LOGFILTER_MIN_LEVEL=WARNING"}, nil, "w e c"},
+		{"flag range over env level", "", []string{"LOGFILTER_LEVEL=WARNING"}, []string{"-max-level", "ERROR"}, "w e"},
+		{"both in the config file", "level: ERROR\nmin-level: WARNING\n", nil, nil, "exit 2"},
+		{"both as flags", "", nil, []string{"-level", "ERROR", "-min-level", "WARNING"}, "exit 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			if tt.config != "" {
+				env = append([]string{envPrefix + "CONFIG=" + writeTemp(t, "config.yaml", tt.config)}, env...)
+			}
+			args := append(append([]string{"filter", "-fields", "message"}, tt.args...), input)
+			stdout, stderr, code := runMain(t, env, "", args...)
+			got := strings.Join(strings.Fields(stdout), " ")
+			if code == exitError {
+				got = "exit 2"
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q; stderr:\n%s", got, tt.want, stderr)
+			}
+		})
+	}
+}
+
+func TestConfigShowMasksRedactKey(t *testing.T) {
+	const secret = "hunter2-hmac-key"
+	tests := []struct {
+		name   string
+		config string
+		env    []string
+		args   []string
+		want   string
+	}{
+		{"unset", "", nil, nil, "redact-key default"},
+		{"config file", "redact-key: " + secret + "\n", nil, nil, "redact-key (set) CONFIG"},
+		{"env", "", []string{"LOGFILTER_REDACT_KEY=" + secret}, nil, "redact-key (set) env LOGFILTER_REDACT_KEY"},
+		{"flag", "", nil, []string{"-redact-key", secret}, "redact-key (set) flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			want := tt.want
+			if tt.config != "" {
+				path := writeTemp(t, "config.yaml", tt.config)
+				env = append([]string{envPrefix + "CONFIG=" + path}, env...)
+				want = strings.ReplaceAll(want, "CONFIG", path)
+			}
+			if got := showSetting(t, env, tt.args, "redact-key"); got != want {
+				t.Errorf("config show: %q, want %q", got, want)
+			}
+			stdout, _, _ := runMain(t, env, "", append([]string{"config", "show"}, tt.args...)...)
+			if strings.Contains(stdout, secret) {
+				t.Errorf("config show prints the key:\n%s", stdout)
+			}
+		})
+	}
+}
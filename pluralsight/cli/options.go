@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"cli/logfilter"
+)
+
+// options holds every setting of the commands. The flags of the filtering
+// commands are bound straight to the fields of logfilter.Options; the rest
+// only concern the command line. Each subcommand registers only the flag
+// groups that make sense for it; the fields of the other groups keep the
+// defaults set by newOptions.
+type options struct {
+	logfilter.Options
+
+	recursive    bool   // -r
+	contextLines int    // -C, applied to Before and After by filterCommand
+	kafkaBrokers string // -kafka-brokers, for NewProducer
+	quiet        bool   // validate -q
+
+	// Set from the config file and environment by parseArgs.
+	configPath    string
+	configFound   bool
+	defaultInputs []string
+	queries       map[string]string
+}
+
+func newOptions() *options {
+	return &options{
+		Options: logfilter.Options{
+			Workers:    runtime.NumCPU(),
+			TimeLayout: logfilter.DefaultTimeLayout,
+			TimeZone:   "UTC",
+			Format:     "auto",
+			Level:      "CRITICAL",
+			Poll:       logfilter.DefaultPollInterval,
+			Output:     "text",
+			Color:      "auto",
+			Bucket:     "hour",
+			Top:        10,
+			KafkaBatch: logfilter.DefaultKafkaBatch,
+		},
+		kafkaBrokers: "localhost:9092",
+
+		// Without arguments the sample log.txt next to the program is read,
+		// so `go run .` works out of the box.
+		defaultInputs: []string{"log.txt"},
+	}
+}
+
+// inputFlags registers the flags choosing which files are read.
+func (o *options) inputFlags(fs *flag.FlagSet) {
+	// Input handling flags, modelled on grep: -r walks directories, -H prefixes
+	// every printed line with the name of the file it came from.
+	fs.BoolVar(&o.recursive, "r", o.recursive, "Read all files under directory arguments recursively")
+	fs.BoolVar(&o.WithSource, "H", o.WithSource, "Prefix each output line with its source file name")
+
+	// -workers sets how many goroutines scan a large file in parallel. The file is split
+	// into line-aligned chunks and matches are printed in their original order.
+	fs.IntVar(&o.Workers, "workers", o.Workers, "Number of goroutines used to scan large files (1 disables parallel scanning)")
+
+	// Files indexed with `index` are searched through their sidecar index while they
+	// are unchanged; -no-index forces a full scan.
+	fs.BoolVar(&o.IgnoreIndex, "no-index", o.IgnoreIndex, "Ignore sidecar indexes and always scan files in full")
+}
+
+// mergeFlags registers -merge and -offset.
+func (o *options) mergeFlags(fs *flag.FlagSet) {
+	// -merge interleaves the records of all inputs by time, as if the services had
+	// written one log. -offset corrects the clock of one input, e.g. db.log=-1.5s when
+	// the database host runs 1.5 seconds ahead; it may be repeated.
+	fs.BoolVar(&o.Merge, "merge", o.Merge, "Print the records of all inputs merged in time order")
+	fs.Func("offset", "Clock correction `FILE=DURATION` added to the timestamps of one input with -merge (repeatable)", func(s string) error {
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return fmt.Errorf("want FILE=DURATION")
+		}
+		d, err := time.ParseDuration(s[i+1:])
+		if err != nil {
+			return err
+		}
+		if o.Offsets == nil {
+			o.Offsets = map[string]time.Duration{}
+		}
+		o.Offsets[s[:i]] = d
+		return nil
+	})
+}
+
+// parseFlags registers the flags describing how lines are parsed.
+func (o *options) parseFlags(fs *flag.FlagSet) {
+	// -time-layout and -tz describe how timestamps are written in the logs.
+	fs.StringVar(&o.TimeLayout, "time-layout", o.TimeLayout, "Timestamp layout of the logs, as a Go layout or a name like rfc3339")
+	fs.StringVar(&o.TimeZone, "tz", o.TimeZone, "Time zone of timestamps without an offset (e.g. Local, Europe/Berlin)")
+
+	// -format names the input format. "auto" detects it for every input from its first
+	// lines, so plain, JSON-lines, logfmt, syslog and access logs can be mixed.
+	fs.StringVar(&o.Format, "format", o.Format, "Input format: auto, plain, json, logfmt, syslog or access")
+
+	// -multiline keeps stack traces together: lines that are indented or do not start
+	// with a timestamp belong to the record above them, so -level CRITICAL prints the
+	// whole trace. -record-start replaces that rule with a regular expression.
+	fs.BoolVar(&o.Multiline, "multiline", o.Multiline, "Group continuation lines (e.g. stack traces) into the preceding record")
+	fs.StringVar(&o.RecordStart, "record-start", o.RecordStart, "Regular expression matching the first line of a record (implies -multiline)")
+}
+
+// extractFlags registers the field extraction flags.
+func (o *options) extractFlags(fs *flag.FlagSet) {
+	// Field extraction turns parts of the message into named fields that -query and
+	// -fields can use. -extract takes a regular expression with named groups and may be
+	// repeated; fs.Func calls the function once per occurrence. -kv picks up
+	// key=value pairs. JSON and logfmt lines provide their extra keys as fields anyway.
+	fs.Func("extract", "A `regex` whose named groups, e.g. (?P<user_id>\\d+), become fields (repeatable)", func(pattern string) error {
+		x, err := logfilter.NewRegexExtractor(pattern)
+		if err != nil {
+			return err
+		}
+		o.Extractors = append(o.Extractors, x)
+		return nil
+	})
+	fs.BoolVar(&o.KeyValues, "kv", o.KeyValues, "Extract key=value pairs from messages as fields")
+}
+
+// filterFlags registers the flags selecting records.
+func (o *options) filterFlags(fs *flag.FlagSet) {
+	// fs.StringVar defines a string flag named "level" stored in o.Level.
+	// The third argument is the default value if the flag is not provided.
+	// The fourth argument is the usage message printed if the user asks for help.
+	fs.StringVar(&o.Level, "level", o.Level, "Log level to filter for")
+
+	// fs.BoolVar defines a boolean flag; it is false unless passed as -show-invalid.
+	fs.BoolVar(&o.ShowInvalid, "show-invalid", o.ShowInvalid, "Print lines that cannot be parsed to stderr")
+
+	// -min-level and -max-level select a range of severities instead of one exact level,
+	// e.g. -min-level WARNING prints WARNING, ERROR, CRITICAL, ALERT and EMERGENCY lines.
+	fs.StringVar(&o.MinLevel, "min-level", o.MinLevel, "Lowest log level to print (enables range mode)")
+	fs.StringVar(&o.MaxLevel, "max-level", o.MaxLevel, "Highest log level to print (enables range mode)")
+
+	// Time filtering flags. -since and -until take absolute times or durations
+	// relative to now ("2h" means two hours ago).
+	fs.StringVar(&o.Since, "since", o.Since, "Only print lines at or after this time (e.g. 2h, 02:00, 2024-05-01T02:00:00Z)")
+	fs.StringVar(&o.Until, "until", o.Until, "Only print lines before this time (same formats as -since)")
+
+	// -query takes a boolean expression over the parsed fields, such as
+	// 'level>=ERROR AND message =~ /connect/ AND NOT message contains "gRPC"'.
+	fs.StringVar(&o.Query, "query", o.Query, "Boolean filter expression over level, message, time, source, raw and extracted fields")
+}
+
+// contextFlags registers grep's context flags.
+func (o *options) contextFlags(fs *flag.FlagSet) {
+	// Context flags, also as in grep: -B prints lines before each match, -A lines after
+	// it and -C both. Groups that are not adjacent are separated by a "--" line.
+	fs.IntVar(&o.Before, "B", o.Before, "Print this many lines of context before each match")
+	fs.IntVar(&o.After, "A", o.After, "Print this many lines of context after each match")
+	fs.IntVar(&o.contextLines, "C", o.contextLines, "Print this many lines of context before and after each match")
+}
+
+// followFlags registers -f and -poll; withF is false for commands that
+// always follow.
+func (o *options) followFlags(fs *flag.FlagSet, withF bool) {
+	// -f keeps the program running and prints matching lines as they are appended,
+	// like `tail -F`. fs.DurationVar parses values such as "500ms" or "2s".
+	if withF {
+		fs.BoolVar(&o.Follow, "f", o.Follow, "Follow inputs for appended lines, surviving log rotation")
+	}
+	fs.DurationVar(&o.Poll, "poll", o.Poll, "How often followed files are checked for new data")
+}
+
+// outputFlags registers the flags shaping printed records.
+func (o *options) outputFlags(fs *flag.FlagSet) {
+	// -output selects how matching records are printed: the original text line, or
+	// structured records (a JSON array, one JSON object per line, or CSV) for other tools.
+	fs.StringVar(&o.Output, "output", o.Output, "Output format: text, json, ndjson or csv")
+
+	// -fields prints only the named fields, e.g. "ts,level,user_id", instead of whole lines.
+	fs.StringVar(&o.Fields, "fields", o.Fields, "Comma-separated fields to print (e.g. ts,level,message,user_id)")
+
+	// -color prints text output in aligned columns with levels coloured by severity and
+	// the text matched by -query highlighted. "auto" does so only when standard output is
+	// a terminal and the NO_COLOR environment variable is not set.
+	fs.StringVar(&o.Color, "color", o.Color, "Colour text output: auto, always or never")
+
+	// -dedupe drops records that repeat the one before them and prints "last message
+	// repeated N times" instead, like syslog. -dedupe-window also catches repeats with
+	// other records in between, as long as they are less than the window apart.
+	fs.BoolVar(&o.Dedupe, "dedupe", o.Dedupe, "Collapse consecutive repeated messages into a count")
+	fs.DurationVar(&o.DedupeWindow, "dedupe-window", o.DedupeWindow, "Collapse repeated messages within this time window, e.g. 1m (implies -dedupe)")
+}
+
+// redactFlags registers the redaction flags.
+func (o *options) redactFlags(fs *flag.FlagSet) {
+	// -redact masks emails, bearer tokens, IP addresses and card numbers in everything
+	// printed, so the output can be pasted into a ticket. Filtering still sees the original
+	// text. -redact-pattern adds patterns of its own (a group named "secret" limits the
+	// mask to that part) and -redact-hash gives every value a stable placeholder.
+	fs.BoolVar(&o.Redact, "redact", o.Redact, "Mask emails, bearer tokens, IPs and card numbers in the output")
+	fs.Func("redact-pattern", "Additional `regex` to mask in the output (repeatable, implies -redact)", func(pattern string) error {
+		o.RedactPatterns = append(o.RedactPatterns, pattern)
+		return nil
+	})
+	fs.BoolVar(&o.RedactHash, "redact-hash", o.RedactHash, "Replace masked values with a deterministic hash instead of a fixed placeholder")
+	fs.StringVar(&o.RedactKey, "redact-key", o.RedactKey, "Secret key for -redact-hash, so hashes cannot be reversed by guessing values")
+}
+
+// summaryFlags registers the flags of the summary reports; withModes is
+// false for the stats command, which is always in a summary mode.
+func (o *options) summaryFlags(fs *flag.FlagSet, withModes bool) {
+	// -stats replaces the matching lines with a summary report: counts per level and
+	// per time bucket, the first and last timestamp, and the most frequent messages.
+	if withModes {
+		fs.BoolVar(&o.Stats, "stats", o.Stats, "Print summary statistics instead of matching lines")
+
+		// -cluster collapses repetitive lines: variable parts such as numbers, quoted
+		// strings, IDs and IPs are replaced by placeholders and each resulting template
+		// is printed once with its count and an example line.
+		fs.BoolVar(&o.Cluster, "cluster", o.Cluster, "Group messages into templates and print each with its count")
+	}
+	fs.StringVar(&o.Bucket, "bucket", o.Bucket, "Time bucket for statistics: hour, minute, day or a duration like 15m")
+	fs.IntVar(&o.Top, "top", o.Top, "Number of most frequent messages shown in statistics")
+}
+
+// kafkaFlags registers the flags of the Kafka output.
+func (o *options) kafkaFlags(fs *flag.FlagSet) {
+	// -kafka-topic publishes the matching records as JSON objects to a Kafka topic
+	// instead of printing them, so they flow into an event pipeline. The client needs
+	// cgo (see kafka.go).
+	fs.StringVar(&o.KafkaTopic, "kafka-topic", o.KafkaTopic, "Publish matching records as JSON to this Kafka `topic` instead of printing them")
+	fs.StringVar(&o.kafkaBrokers, "kafka-brokers", o.kafkaBrokers, "Comma-separated Kafka bootstrap servers")
+	fs.IntVar(&o.KafkaBatch, "kafka-batch", o.KafkaBatch, "Number of records published before waiting for their delivery")
+}
+
+// newKafkaProducer connects to Kafka. It is nil in builds without cgo.
+var newKafkaProducer func(brokers string, batch int) (logfilter.Producer, error)
+
+// alertFlags registers -alerts.
+func (o *options) alertFlags(fs *flag.FlagSet) {
+	// -alerts turns the command into a watcher: instead of printing matches, every record
+	// is checked against the rules in a YAML or JSON file ("more than 5 CRITICAL lines in
+	// 1m", "any EMERGENCY"), which write to stderr, run a command or call a webhook.
+	// It is meant to be combined with following.
+	fs.StringVar(&o.Alerts, "alerts", o.Alerts, "YAML or JSON `file` of alert rules to evaluate instead of printing matches")
+}